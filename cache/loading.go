@@ -0,0 +1,273 @@
+// Package cache implements caching strategies on top of the stores of its subpackages.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+)
+
+// ErrNotFound is returned by loaders for missing values and by LoadingCache for missing or negatively cached values.
+var ErrNotFound = redis.ErrNotFound
+
+// negativeMarker is stored for missing values, it's never produced by the encoding of a value
+var negativeMarker = []byte{0}
+
+// Store is implemented by *redis.Redis, values are encoded by its codec.
+type Store interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	GetManyBytes(ctx context.Context, keys ...string) ([][]byte, error)
+	SetBytes(ctx context.Context, key string, value []byte, expiration time.Duration) error
+}
+
+// Loader loads the value of the key on a cache miss, it returns ErrNotFound if there is no value.
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// BatchLoader loads the values of the keys on cache misses, keys missing in the result have no value.
+type BatchLoader[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+type LoadingOption func(o *loadingOptions)
+
+type loadingOptions struct {
+	keyPrefix   string
+	negativeTTL time.Duration
+	metric      LoadingCacheMetric
+}
+
+// WithKeyPrefix sets the prefix of the store keys, the key is formatted by fmt.Sprint after it.
+func WithKeyPrefix(prefix string) LoadingOption {
+	return func(o *loadingOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithNegativeTTL enables caching of missing values for the given time, it's usually shorter than the TTL of values.
+func WithNegativeTTL(ttl time.Duration) LoadingOption {
+	return func(o *loadingOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+func WithLoadingCacheMetric(metric LoadingCacheMetric) LoadingOption {
+	return func(o *loadingOptions) {
+		o.metric = metric
+	}
+}
+
+// LoadingCache is a read-through cache, which loads missing values by the loader and stores them
+// encoded by the codec of the store, see redis.Redis.WithCodec.
+//
+// Concurrent loads of the same key within the process are deduplicated, the callers get the result of a single load.
+// The load runs with the context of the first caller. Failures of the store are logged
+// and handled as misses, so the values are loaded while the store is unavailable.
+type LoadingCache[K comparable, V any] struct {
+	store       Store
+	loader      Loader[K, V]
+	batchLoader BatchLoader[K, V]
+	ttl         time.Duration
+	options     *loadingOptions
+	group       singleflight.Group
+}
+
+func NewLoadingCache[K comparable, V any](store Store, loader Loader[K, V], ttl time.Duration, opts ...LoadingOption) *LoadingCache[K, V] {
+	options := &loadingOptions{metric: &NullableLoadingCacheMetric{}}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &LoadingCache[K, V]{
+		store:   store,
+		loader:  loader,
+		ttl:     ttl,
+		options: options,
+	}
+}
+
+// WithBatchLoader makes GetMany load all missing values at once instead of loading them one by one.
+func (c *LoadingCache[K, V]) WithBatchLoader(loader BatchLoader[K, V]) *LoadingCache[K, V] {
+	c.batchLoader = loader
+	return c
+}
+
+// Get returns the cached value of the key or loads it. ErrNotFound is returned if there is no value.
+func (c *LoadingCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	storeKey := c.storeKey(key)
+
+	data, err := c.store.GetBytes(ctx, storeKey)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Warnf("Get %s from cache: %v", storeKey, err)
+	}
+
+	if value, found, ok := c.decode(storeKey, data); ok {
+		if !found {
+			c.options.metric.NegativeHit(1)
+			return value, ErrNotFound
+		}
+		c.options.metric.Hit(1)
+		return value, nil
+	}
+	c.options.metric.Miss(1)
+
+	return c.loadOnce(ctx, key, storeKey)
+}
+
+// GetMany returns the values of the keys, missing values are omitted from the result.
+// Missing values are loaded by the batch loader if it's set.
+func (c *LoadingCache[K, V]) GetMany(ctx context.Context, keys []K) (map[K]V, error) {
+	result := make(map[K]V, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	unique := make([]K, 0, len(keys))
+	storeKeys := make([]string, 0, len(keys))
+	seen := make(map[K]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+			storeKeys = append(storeKeys, c.storeKey(key))
+		}
+	}
+
+	values, err := c.store.GetManyBytes(ctx, storeKeys...)
+	if err != nil {
+		log.Warnf("Get %d keys from cache: %v", len(storeKeys), err)
+		values = make([][]byte, len(storeKeys))
+	}
+
+	var misses []K
+	var hits, negativeHits int
+	for i, key := range unique {
+		value, found, ok := c.decode(storeKeys[i], values[i])
+		switch {
+		case !ok:
+			misses = append(misses, key)
+		case found:
+			hits++
+			result[key] = value
+		default:
+			negativeHits++
+		}
+	}
+	c.options.metric.Hit(hits)
+	c.options.metric.NegativeHit(negativeHits)
+	c.options.metric.Miss(len(misses))
+
+	if len(misses) == 0 {
+		return result, nil
+	}
+
+	if c.batchLoader == nil {
+		for _, key := range misses {
+			value, err := c.loadOnce(ctx, key, c.storeKey(key))
+			if errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		}
+		return result, nil
+	}
+
+	start := time.Now()
+	loaded, err := c.batchLoader(ctx, misses)
+	c.options.metric.Loaded(time.Since(start), err)
+	if err != nil {
+		return nil, fmt.Errorf("load %d values: %w", len(misses), err)
+	}
+
+	for _, key := range misses {
+		value, found := loaded[key]
+		c.put(ctx, c.storeKey(key), value, found)
+		if found {
+			result[key] = value
+		}
+	}
+
+	return result, nil
+}
+
+// loadOnce deduplicates concurrent loads of the key
+func (c *LoadingCache[K, V]) loadOnce(ctx context.Context, key K, storeKey string) (V, error) {
+	result, err, _ := c.group.Do(storeKey, func() (interface{}, error) {
+		return c.load(ctx, key, storeKey)
+	})
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+
+	// the result is nil for a nil interface value
+	value, _ := result.(V)
+	return value, nil
+}
+
+func (c *LoadingCache[K, V]) load(ctx context.Context, key K, storeKey string) (V, error) {
+	start := time.Now()
+	value, err := c.loader(ctx, key)
+
+	if errors.Is(err, ErrNotFound) {
+		c.options.metric.Loaded(time.Since(start), nil)
+		c.put(ctx, storeKey, value, false)
+		return value, ErrNotFound
+	}
+
+	c.options.metric.Loaded(time.Since(start), err)
+	if err != nil {
+		return value, fmt.Errorf("load %s: %w", storeKey, err)
+	}
+
+	c.put(ctx, storeKey, value, true)
+	return value, nil
+}
+
+// put stores the value or the negative marker, failures are only logged since the value is loaded anyway
+func (c *LoadingCache[K, V]) put(ctx context.Context, storeKey string, value V, found bool) {
+	data, ttl := negativeMarker, c.options.negativeTTL
+	if found {
+		var err error
+		if data, err = c.store.Encode(value); err != nil {
+			log.Errorf("Encode %s: %v", storeKey, err)
+			return
+		}
+		ttl = c.ttl
+	} else if ttl <= 0 {
+		return
+	}
+
+	if err := c.store.SetBytes(ctx, storeKey, data, ttl); err != nil {
+		log.Warnf("Set %s to cache: %v", storeKey, err)
+	}
+}
+
+// decode returns ok false for a miss, found false for a negatively cached value
+func (c *LoadingCache[K, V]) decode(storeKey string, data []byte) (value V, found, ok bool) {
+	if data == nil {
+		return value, false, false
+	}
+	if bytes.Equal(data, negativeMarker) {
+		return value, false, true
+	}
+
+	if err := c.store.Decode(data, &value); err != nil {
+		log.Warnf("Decode %s from cache: %v", storeKey, err)
+		return value, false, false
+	}
+
+	return value, true, true
+}
+
+func (c *LoadingCache[K, V]) storeKey(key K) string {
+	return c.options.keyPrefix + fmt.Sprint(key)
+}
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+)
+
+type token struct {
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+type recordingLoadingCacheMetric struct {
+	mu                         sync.Mutex
+	hits, negativeHits, misses int
+	loads, loadErrors          int
+}
+
+func (m *recordingLoadingCacheMetric) Hit(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hits += count
+}
+
+func (m *recordingLoadingCacheMetric) NegativeHit(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.negativeHits += count
+}
+
+func (m *recordingLoadingCacheMetric) Miss(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses += count
+}
+
+func (m *recordingLoadingCacheMetric) Loaded(_ time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loads++
+	if err != nil {
+		m.loadErrors++
+	}
+}
+
+func newTestStore(t *testing.T) (*redis.Redis, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	store, err := redis.Init(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	require.NoError(t, err)
+
+	return store, mr
+}
+
+var tokens = map[string]token{
+	"btc": {Symbol: "BTC", Decimals: 8},
+	"eth": {Symbol: "ETH", Decimals: 18},
+}
+
+func loadToken(loads *int32) Loader[string, token] {
+	return func(_ context.Context, key string) (token, error) {
+		atomic.AddInt32(loads, 1)
+		t, ok := tokens[key]
+		if !ok {
+			return token{}, ErrNotFound
+		}
+		return t, nil
+	}
+}
+
+func TestLoadingCache_Get(t *testing.T) {
+	store, mr := newTestStore(t)
+	metric := &recordingLoadingCacheMetric{}
+	var loads int32
+
+	c := NewLoadingCache(store, loadToken(&loads), time.Hour,
+		WithKeyPrefix("token:"), WithNegativeTTL(time.Minute), WithLoadingCacheMetric(metric))
+	ctx := context.Background()
+
+	value, err := c.Get(ctx, "btc")
+	require.NoError(t, err)
+	assert.Equal(t, tokens["btc"], value)
+
+	value, err = c.Get(ctx, "btc")
+	require.NoError(t, err)
+	assert.Equal(t, tokens["btc"], value)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	var stored token
+	require.NoError(t, store.Get(ctx, "token:btc", &stored), "values must be readable by Redis.Get")
+	assert.Equal(t, tokens["btc"], stored)
+	assert.Equal(t, time.Hour, mr.TTL("token:btc"))
+
+	_, err = c.Get(ctx, "doge")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = c.Get(ctx, "doge")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads), "missing value must be cached")
+	assert.Equal(t, time.Minute, mr.TTL("token:doge"))
+
+	assert.Equal(t, 1, metric.hits)
+	assert.Equal(t, 1, metric.negativeHits)
+	assert.Equal(t, 2, metric.misses)
+	assert.Equal(t, 2, metric.loads)
+}
+
+func TestLoadingCache_GetWithCodec(t *testing.T) {
+	store, _ := newTestStore(t)
+	codecStore, err := store.WithCodec(redis.MsgpackCodec{}, redis.WithCompression(redis.Snappy, 0))
+	require.NoError(t, err)
+
+	var loads int32
+	c := NewLoadingCache(codecStore, loadToken(&loads), time.Hour, WithNegativeTTL(time.Minute))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		value, err := c.Get(ctx, "btc")
+		require.NoError(t, err)
+		assert.Equal(t, tokens["btc"], value)
+
+		_, err = c.Get(ctx, "doge")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	data, err := store.GetBytes(ctx, "btc")
+	require.NoError(t, err)
+	assert.False(t, json.Valid(data), "values must be encoded by the codec")
+
+	var stored token
+	require.NoError(t, store.Get(ctx, "btc", &stored))
+	assert.Equal(t, tokens["btc"], stored)
+}
+
+func TestLoadingCache_GetNilInterface(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	c := NewLoadingCache(store, func(context.Context, string) (fmt.Stringer, error) {
+		return nil, nil
+	}, time.Hour)
+
+	value, err := c.Get(context.Background(), "btc")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestLoadingCache_GetDeduplicatesLoads(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	var loads int32
+	release := make(chan struct{})
+	c := NewLoadingCache(store, func(ctx context.Context, key string) (token, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return tokens[key], nil
+	}, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := c.Get(context.Background(), "eth")
+			assert.NoError(t, err)
+			assert.Equal(t, tokens["eth"], value)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestLoadingCache_GetLoadError(t *testing.T) {
+	store, mr := newTestStore(t)
+	metric := &recordingLoadingCacheMetric{}
+
+	c := NewLoadingCache(store, func(context.Context, int) (token, error) {
+		return token{}, errors.New("node is down")
+	}, time.Hour, WithNegativeTTL(time.Minute), WithLoadingCacheMetric(metric))
+
+	_, err := c.Get(context.Background(), 1)
+	assert.EqualError(t, err, "load 1: node is down")
+	assert.False(t, mr.Exists("1"), "errors must not be cached")
+	assert.Equal(t, 1, metric.loadErrors)
+}
+
+func TestLoadingCache_GetStoreUnavailable(t *testing.T) {
+	store, mr := newTestStore(t)
+	mr.Close()
+
+	var loads int32
+	c := NewLoadingCache(store, loadToken(&loads), time.Hour)
+
+	value, err := c.Get(context.Background(), "btc")
+	require.NoError(t, err)
+	assert.Equal(t, tokens["btc"], value)
+}
+
+func TestLoadingCache_GetMany(t *testing.T) {
+	store, _ := newTestStore(t)
+	metric := &recordingLoadingCacheMetric{}
+	ctx := context.Background()
+
+	var batches [][]string
+	c := NewLoadingCache(store, loadToken(new(int32)), time.Hour,
+		WithNegativeTTL(time.Minute), WithLoadingCacheMetric(metric)).
+		WithBatchLoader(func(_ context.Context, keys []string) (map[string]token, error) {
+			batches = append(batches, keys)
+			result := map[string]token{}
+			for _, key := range keys {
+				if t, ok := tokens[key]; ok {
+					result[key] = t
+				}
+			}
+			return result, nil
+		})
+
+	_, err := c.Get(ctx, "btc")
+	require.NoError(t, err)
+
+	result, err := c.GetMany(ctx, []string{"btc", "eth", "doge", "eth"})
+	require.NoError(t, err)
+	assert.Equal(t, tokens, result)
+	assert.Equal(t, [][]string{{"eth", "doge"}}, batches)
+
+	result, err = c.GetMany(ctx, []string{"btc", "eth", "doge"})
+	require.NoError(t, err)
+	assert.Equal(t, tokens, result)
+	assert.Len(t, batches, 1, "loaded and missing values must be cached")
+
+	assert.Equal(t, 3, metric.hits)
+	assert.Equal(t, 1, metric.negativeHits)
+	assert.Equal(t, 3, metric.misses)
+}
+
+func TestLoadingCache_GetManyCluster(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	store, err := redis.InitCluster(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	require.NoError(t, err)
+
+	metric := &recordingLoadingCacheMetric{}
+	c := NewLoadingCache(store, loadToken(new(int32)), time.Hour, WithLoadingCacheMetric(metric))
+
+	_, err = c.GetMany(context.Background(), []string{"btc", "eth"})
+	require.NoError(t, err)
+	result, err := c.GetMany(context.Background(), []string{"btc", "eth"})
+	require.NoError(t, err)
+	assert.Equal(t, tokens, result)
+	assert.Equal(t, 2, metric.hits, "values must be read from the cluster")
+}
+
+func TestLoadingCache_GetManyWithoutBatchLoader(t *testing.T) {
+	store, _ := newTestStore(t)
+	var loads int32
+
+	c := NewLoadingCache(store, loadToken(&loads), time.Hour)
+
+	result, err := c.GetMany(context.Background(), []string{"btc", "eth", "doge"})
+	require.NoError(t, err)
+	assert.Equal(t, tokens, result)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&loads))
+}
+
+func TestNewLoadingCacheMetric(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metric := NewLoadingCacheMetric("test", prometheus.Labels{"cache": "tokens"}, reg)
+
+	metric.Hit(2)
+	metric.Miss(1)
+	metric.Loaded(time.Millisecond, errors.New("failed"))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	assert.True(t, names["test_cache_requests_total"])
+	assert.True(t, names["test_cache_load_duration_seconds"])
+	assert.True(t, names["test_cache_load_errors_total"])
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	c := newLRU[int](2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.add("a", 1, time.Minute)
+	c.add("b", 2, time.Minute)
+	_, ok := c.get("a")
+	assert.True(t, ok)
+
+	c.add("c", 3, time.Minute)
+	_, ok = c.get("b")
+	assert.False(t, ok, "the least recently used entry must be evicted")
+	assert.Equal(t, 2, c.len())
+
+	c.add("a", 10, time.Second)
+	value, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 10, value)
+
+	now = now.Add(time.Second)
+	_, ok = c.get("a")
+	assert.False(t, ok, "expired entry must not be returned")
+	assert.Equal(t, 1, c.len())
+
+	c.remove("c")
+	assert.Equal(t, 0, c.len())
+
+	c.add("a", 1, time.Minute)
+	c.add("b", 2, time.Minute)
+	c.purge()
+	assert.Equal(t, 0, c.len())
+	_, ok = c.get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+const (
+	requestsTotalKey       = "cache_requests_total"
+	loadDurationSecondsKey = "cache_load_duration_seconds"
+	loadErrorsTotalKey     = "cache_load_errors_total"
+	tierRequestsTotalKey   = "cache_tier_requests_total"
+	invalidationsTotalKey  = "cache_invalidations_total"
+)
+
+const (
+	labelResult       = "result"
+	resultHit         = "hit"
+	resultNegativeHit = "negative_hit"
+	resultMiss        = "miss"
+	resultLocalHit    = "local_hit"
+	resultRedisHit    = "redis_hit"
+)
+
+// LoadingCacheMetric records the lookups and the loads of LoadingCache.
+type LoadingCacheMetric interface {
+	Hit(count int)
+	NegativeHit(count int)
+	Miss(count int)
+	// Loaded records a call of the loader, loads of missing values are successful.
+	Loaded(duration time.Duration, err error)
+}
+
+type loadingCacheMetric struct {
+	requestsTotal       *prometheus.CounterVec
+	loadDurationSeconds prometheus.Histogram
+	loadErrorsTotal     prometheus.Counter
+}
+
+func NewLoadingCacheMetric(
+	namespace string,
+	staticLabels prometheus.Labels,
+	reg prometheus.Registerer,
+) LoadingCacheMetric {
+	requestsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      requestsTotalKey,
+		Help:      "Total number of cache lookups by result.",
+	}, []string{labelResult})
+
+	loadDurationSeconds := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      loadDurationSecondsKey,
+		Help:      "Duration of loading values on cache misses.",
+	})
+
+	loadErrorsTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      loadErrorsTotalKey,
+		Help:      "Total number of failed loads of values on cache misses.",
+	})
+
+	metrics.Register(staticLabels, reg, requestsTotal, loadDurationSeconds, loadErrorsTotal)
+
+	return &loadingCacheMetric{
+		requestsTotal:       requestsTotal,
+		loadDurationSeconds: loadDurationSeconds,
+		loadErrorsTotal:     loadErrorsTotal,
+	}
+}
+
+func (m *loadingCacheMetric) Hit(count int) {
+	m.requestsTotal.WithLabelValues(resultHit).Add(float64(count))
+}
+
+func (m *loadingCacheMetric) NegativeHit(count int) {
+	m.requestsTotal.WithLabelValues(resultNegativeHit).Add(float64(count))
+}
+
+func (m *loadingCacheMetric) Miss(count int) {
+	m.requestsTotal.WithLabelValues(resultMiss).Add(float64(count))
+}
+
+func (m *loadingCacheMetric) Loaded(duration time.Duration, err error) {
+	m.loadDurationSeconds.Observe(duration.Seconds())
+	if err != nil {
+		m.loadErrorsTotal.Inc()
+	}
+}
+
+type NullableLoadingCacheMetric struct{}
+
+func (NullableLoadingCacheMetric) Hit(_ int)                       {}
+func (NullableLoadingCacheMetric) NegativeHit(_ int)               {}
+func (NullableLoadingCacheMetric) Miss(_ int)                      {}
+func (NullableLoadingCacheMetric) Loaded(_ time.Duration, _ error) {}
+
+// TieredCacheMetric records the lookups by tier and the invalidations received by TieredCache.
+type TieredCacheMetric interface {
+	LocalHit()
+	RedisHit()
+	Miss()
+	Invalidated()
+}
+
+type tieredCacheMetric struct {
+	requestsTotal      *prometheus.CounterVec
+	invalidationsTotal prometheus.Counter
+}
+
+func NewTieredCacheMetric(
+	namespace string,
+	staticLabels prometheus.Labels,
+	reg prometheus.Registerer,
+) TieredCacheMetric {
+	requestsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      tierRequestsTotalKey,
+		Help:      "Total number of tiered cache lookups by result.",
+	}, []string{labelResult})
+
+	invalidationsTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      invalidationsTotalKey,
+		Help:      "Total number of local values invalidated by other replicas.",
+	})
+
+	metrics.Register(staticLabels, reg, requestsTotal, invalidationsTotal)
+
+	return &tieredCacheMetric{
+		requestsTotal:      requestsTotal,
+		invalidationsTotal: invalidationsTotal,
+	}
+}
+
+func (m *tieredCacheMetric) LocalHit() {
+	m.requestsTotal.WithLabelValues(resultLocalHit).Inc()
+}
+
+func (m *tieredCacheMetric) RedisHit() {
+	m.requestsTotal.WithLabelValues(resultRedisHit).Inc()
+}
+
+func (m *tieredCacheMetric) Miss() {
+	m.requestsTotal.WithLabelValues(resultMiss).Inc()
+}
+
+func (m *tieredCacheMetric) Invalidated() {
+	m.invalidationsTotal.Inc()
+}
+
+type NullableTieredCacheMetric struct{}
+
+func (NullableTieredCacheMetric) LocalHit()    {}
+func (NullableTieredCacheMetric) RedisHit()    {}
+func (NullableTieredCacheMetric) Miss()        {}
+func (NullableTieredCacheMetric) Invalidated() {}
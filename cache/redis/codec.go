@@ -0,0 +1,310 @@
+package redis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	ugorji "github.com/ugorji/go/codec"
+
+	"github.com/trustwallet/go-libs/pkg/protocodec"
+)
+
+// Values encoded by a codec other than plain JSON start with the header byte 1ccc iiii,
+// where ccc is the Compression and iiii is the codec ID. Plain JSON never starts with a byte
+// with the high bit set, so values written before codecs were introduced remain readable.
+const (
+	headerFlag            = 0x80
+	headerCompressionBit  = 4
+	headerCompressionMask = 0x70
+	headerCodecMask       = 0x0f
+)
+
+const (
+	CodecIDJSON byte = iota
+	CodecIDMsgpack
+	CodecIDGob
+	CodecIDProtobuf
+)
+
+// Codec encodes values of Redis.Get, Set, MSet, SetNX, SetXX, Encode and Decode. The ID is stored in the header of values,
+// it must be unique and fit into 4 bits.
+type Codec interface {
+	ID() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecs = map[byte]Codec{
+	CodecIDJSON:     JSONCodec{},
+	CodecIDMsgpack:  MsgpackCodec{},
+	CodecIDGob:      GobCodec{},
+	CodecIDProtobuf: ProtobufCodec{},
+}
+
+// JSONCodec is the default codec, it uses encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) ID() byte {
+	return CodecIDJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackCodec encodes values with MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ID() byte {
+	return CodecIDMsgpack
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := ugorji.NewEncoderBytes(&data, &ugorji.MsgpackHandle{}).Encode(v)
+	return data, err
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return ugorji.NewDecoderBytes(data, &ugorji.MsgpackHandle{}).Decode(v)
+}
+
+// GobCodec encodes values with encoding/gob, it's suitable only for values read by Go services.
+type GobCodec struct{}
+
+func (GobCodec) ID() byte {
+	return CodecIDGob
+}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtobufCodec encodes values with Protocol Buffers, values must implement proto.Message.
+// Values can be read into the message or into a pointer to the message pointer.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ID() byte {
+	return CodecIDProtobuf
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	return protocodec.Marshal(v)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	return protocodec.Unmarshal(data, v)
+}
+
+type Compression byte
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zstd
+	Snappy
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("compression(%d)", byte(c))
+	}
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// initZstd creates the shared encoder and decoder, both are safe for concurrent EncodeAll and DecodeAll
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %s", c)
+	}
+}
+
+func decompress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	case Snappy:
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("unknown compression %s", c)
+	}
+}
+
+type CodecOption func(o *codecOptions)
+
+type codecOptions struct {
+	compression Compression
+	threshold   int
+}
+
+// WithCompression compresses values whose encoded size is at least the threshold in bytes.
+func WithCompression(compression Compression, threshold int) CodecOption {
+	return func(o *codecOptions) {
+		o.compression = compression
+		o.threshold = threshold
+	}
+}
+
+// WithCodec returns a copy of Redis writing values by the codec, the copy shares the connection with r,
+// including the connection opened by Reconnect of r or of the copy.
+// Values written by any codec or compression are readable by every copy, so the codec can be changed
+// without migrating stored values. Plain JSON is written without the header to stay readable by older versions.
+func (r *Redis) WithCodec(codec Codec, opts ...CodecOption) (*Redis, error) {
+	if codec.ID() > headerCodecMask {
+		return nil, fmt.Errorf("codec id %d doesn't fit into the header", codec.ID())
+	}
+	if known, ok := codecs[codec.ID()]; ok && reflect.TypeOf(known) != reflect.TypeOf(codec) {
+		return nil, fmt.Errorf("codec id %d is used by %T", codec.ID(), known)
+	}
+
+	options := &codecOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.compression > Snappy {
+		return nil, fmt.Errorf("unknown compression %s", options.compression)
+	}
+
+	c := *r
+	c.codec = codec
+	c.codecOptions = options
+	return &c, nil
+}
+
+// Encode encodes the value like Set, so it can be written by SetBytes and read by Get.
+func (r *Redis) Encode(v interface{}) ([]byte, error) {
+	return r.encode(v)
+}
+
+// Decode decodes a value written by Set or any codec, e.g. a value read by GetBytes.
+func (r *Redis) Decode(data []byte, v interface{}) error {
+	return r.decode(data, v)
+}
+
+func (r *Redis) encode(v interface{}) ([]byte, error) {
+	if r.codec == nil {
+		return json.Marshal(v)
+	}
+
+	data, err := r.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := NoCompression
+	if r.codecOptions.compression != NoCompression && len(data) >= r.codecOptions.threshold {
+		compression = r.codecOptions.compression
+	}
+	if compression == NoCompression && r.codec.ID() == CodecIDJSON {
+		return data, nil
+	}
+
+	if data, err = compress(compression, data); err != nil {
+		return nil, fmt.Errorf("compress with %s: %w", compression, err)
+	}
+
+	header := headerFlag | byte(compression)<<headerCompressionBit | r.codec.ID()
+	return append([]byte{header}, data...), nil
+}
+
+func (r *Redis) decode(data []byte, v interface{}) error {
+	if len(data) == 0 || data[0]&headerFlag == 0 {
+		return json.Unmarshal(data, v)
+	}
+
+	header, data := data[0], data[1:]
+	compression := Compression(header & headerCompressionMask >> headerCompressionBit)
+	id := header & headerCodecMask
+
+	codec, ok := codecs[id]
+	if r.codec != nil && r.codec.ID() == id {
+		codec, ok = r.codec, true
+	}
+	if !ok {
+		return fmt.Errorf("unknown codec id %d", id)
+	}
+
+	data, err := decompress(compression, data)
+	if err != nil {
+		return fmt.Errorf("decompress with %s: %w", compression, err)
+	}
+
+	return codec.Unmarshal(data, v)
+}
@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestValue struct {
+	Name  string
+	Count int
+}
+
+func TestRedis_WithCodec(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	value := codecTestValue{Name: strings.Repeat("token", 100), Count: 7}
+
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}, GobCodec{}} {
+		for _, compression := range []Compression{NoCompression, Gzip, Zstd, Snappy} {
+			t.Run(fmt.Sprintf("%T/%s", codec, compression), func(t *testing.T) {
+				c, err := r.WithCodec(codec, WithCompression(compression, 100))
+				require.NoError(t, err)
+
+				require.NoError(t, c.Set(ctx, "value", value, time.Minute))
+
+				var got codecTestValue
+				require.NoError(t, c.Get(ctx, "value", &got))
+				assert.Equal(t, value, got)
+
+				got = codecTestValue{}
+				require.NoError(t, r.Get(ctx, "value", &got), "values must be readable without the codec")
+				assert.Equal(t, value, got)
+
+				data, err := r.GetBytes(ctx, "value")
+				require.NoError(t, err)
+				if compression != NoCompression {
+					assert.Less(t, len(data), len(value.Name))
+				}
+			})
+		}
+	}
+}
+
+func TestRedis_WithCodecReconnect(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	c, err := r.WithCodec(MsgpackCodec{})
+	require.NoError(t, err)
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+	require.NoError(t, r.Reconnect(ctx, fmt.Sprintf("redis://%s", mr.Addr())))
+
+	require.NoError(t, c.Set(ctx, "value", codecTestValue{Name: "btc"}, time.Minute))
+	assert.True(t, mr.Exists("value"), "the copy must use the reconnected client")
+}
+
+func TestRedis_WithCodecLegacyValues(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, r.Set(ctx, "legacy", codecTestValue{Name: "btc", Count: 1}, time.Minute))
+
+	c, err := r.WithCodec(MsgpackCodec{}, WithCompression(Zstd, 0))
+	require.NoError(t, err)
+
+	var got codecTestValue
+	require.NoError(t, c.Get(ctx, "legacy", &got))
+	assert.Equal(t, codecTestValue{Name: "btc", Count: 1}, got)
+
+	plain, err := r.WithCodec(JSONCodec{}, WithCompression(Gzip, 1000))
+	require.NoError(t, err)
+	require.NoError(t, plain.Set(ctx, "small", codecTestValue{Name: "eth"}, time.Minute))
+
+	data, err := r.GetBytes(ctx, "small")
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"eth","Count":0}`, string(data), "uncompressed JSON must be written without the header")
+}
+
+func TestRedis_WithCodecProtobuf(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	c, err := r.WithCodec(ProtobufCodec{}, WithCompression(Snappy, 0))
+	require.NoError(t, err)
+
+	require.NoError(t, c.Set(ctx, "message", wrapperspb.String("hello"), time.Minute))
+
+	var got *wrapperspb.StringValue
+	require.NoError(t, r.Get(ctx, "message", &got))
+	assert.Equal(t, "hello", got.GetValue())
+
+	assert.Error(t, c.Set(ctx, "message", "hello", time.Minute))
+}
+
+func TestRedis_WithCodecMSet(t *testing.T) {
+	r, err := redisClusterInit(t)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	c, err := r.WithCodec(GobCodec{})
+	require.NoError(t, err)
+
+	require.NoError(t, c.MSet(ctx, map[string]interface{}{"a": codecTestValue{Count: 1}}, time.Minute))
+	ok, err := c.SetNX(ctx, "b", codecTestValue{Count: 2}, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	for key, count := range map[string]int{"a": 1, "b": 2} {
+		var got codecTestValue
+		require.NoError(t, r.Get(ctx, key, &got))
+		assert.Equal(t, count, got.Count)
+	}
+}
+
+type conflictingCodec struct {
+	JSONCodec
+}
+
+func TestRedis_WithCodecInvalid(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+
+	_, err = r.WithCodec(conflictingCodec{})
+	assert.Error(t, err)
+
+	_, err = r.WithCodec(JSONCodec{}, WithCompression(Compression(7), 0))
+	assert.Error(t, err)
+
+	var got codecTestValue
+	assert.EqualError(t, r.decode([]byte{headerFlag | 0x0e, 1}, &got), "unknown codec id 14")
+}
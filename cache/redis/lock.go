@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrNotObtained is returned when the lock is held by someone else after all attempts.
+	ErrNotObtained = errors.New("lock not obtained")
+	// ErrLockNotHeld is returned when the lock has expired or has been obtained by someone else meanwhile.
+	ErrLockNotHeld = errors.New("lock not held")
+)
+
+// obtainScript sets the lock if it's free and increments the fencing counter of the key
+var obtainScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+var refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// RetryStrategy returns the delay before the retry to obtain a lock, attempts start from 1, false means to give up.
+type RetryStrategy func(attempt int) (time.Duration, bool)
+
+// NoRetry gives up after the first attempt.
+func NoRetry() RetryStrategy {
+	return func(int) (time.Duration, bool) {
+		return 0, false
+	}
+}
+
+// LinearRetry retries with the constant delay. A negative maxAttempts value is equal to infinite attempts.
+func LinearRetry(delay time.Duration, maxAttempts int) RetryStrategy {
+	return func(attempt int) (time.Duration, bool) {
+		return delay, maxAttempts < 0 || attempt < maxAttempts
+	}
+}
+
+// ExponentialRetry retries with the delay doubling from min up to max. A negative maxAttempts value is equal to infinite attempts.
+func ExponentialRetry(min, max time.Duration, maxAttempts int) RetryStrategy {
+	return func(attempt int) (time.Duration, bool) {
+		delay := min
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay, maxAttempts < 0 || attempt < maxAttempts
+	}
+}
+
+type LockOption func(o *lockOptions)
+
+type lockOptions struct {
+	retry     RetryStrategy
+	autoRenew bool
+}
+
+// WithRetryStrategy sets the retries to obtain a lock held by someone else, there are no retries by default.
+func WithRetryStrategy(retry RetryStrategy) LockOption {
+	return func(o *lockOptions) {
+		o.retry = retry
+	}
+}
+
+// WithoutAutoRenewal disables the renewal of the lock TTL, the lock expires after the TTL unless it's refreshed.
+func WithoutAutoRenewal() LockOption {
+	return func(o *lockOptions) {
+		o.autoRenew = false
+	}
+}
+
+// Locker obtains distributed locks, it works both in single instance and cluster mode.
+//
+// Lock keys are stored as "lock:{key}", the hash tag keeps the lock and its fencing counter in the same cluster slot.
+// The fencing counter is kept forever to never issue the same fencing token twice.
+type Locker struct {
+	redis *Redis
+}
+
+func NewLocker(r *Redis) *Locker {
+	return &Locker{redis: r}
+}
+
+// Obtain obtains the lock of the key for the TTL. The TTL is renewed automatically until the lock is released,
+// if the renewal fails for the whole TTL, the Lost channel of the lock is closed.
+// ErrNotObtained is returned if the lock is held by someone else.
+func (l *Locker) Obtain(ctx context.Context, key string, ttl time.Duration, opts ...LockOption) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("invalid lock ttl: %s", ttl)
+	}
+
+	options := &lockOptions{retry: NoRetry(), autoRenew: true}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+
+	lockKey := lockKey(key)
+	keys := []string{lockKey, lockKey + ":fencing"}
+
+	for attempt := 1; ; attempt++ {
+		fence, err := obtainScript.Run(ctx, l.redis.conn.client, keys, token, ttl.Milliseconds()).Int64()
+		if err != nil {
+			return nil, fmt.Errorf("obtain lock %s: %w", key, err)
+		}
+
+		if fence > 0 {
+			lock := newLock(l.redis, key, token, fence, ttl)
+			if options.autoRenew {
+				go lock.renew()
+			}
+			return lock, nil
+		}
+
+		delay, retry := options.retry(attempt)
+		if !retry {
+			return nil, ErrNotObtained
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %v", ErrNotObtained, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func lockKey(key string) string {
+	if strings.Contains(key, "{") && strings.Contains(key, "}") {
+		// the key has its own hash tag
+		return "lock:" + key
+	}
+	return "lock:{" + key + "}"
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate lock token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lock is an obtained lock.
+type Lock struct {
+	redis *Redis
+	key   string
+	token string
+	fence int64
+	ttl   time.Duration
+
+	releaseOnce sync.Once
+	released    chan struct{}
+	lost        chan struct{}
+}
+
+func newLock(r *Redis, key, token string, fence int64, ttl time.Duration) *Lock {
+	return &Lock{
+		redis:    r,
+		key:      key,
+		token:    token,
+		fence:    fence,
+		ttl:      ttl,
+		released: make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+}
+
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// FencingToken increases with every obtained lock of the key. Storages protected by the lock
+// should reject writes with a token lower than the last seen one, which come from holders whose lock has expired.
+func (l *Lock) FencingToken() int64 {
+	return l.fence
+}
+
+// Lost is closed when the lock can't be renewed anymore, the holder should stop the work protected by the lock.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Refresh extends the lock TTL. ErrLockNotHeld is returned if the lock has expired or has been obtained by someone else.
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	res, err := refreshScript.Run(ctx, l.redis.conn.client, []string{lockKey(l.key)}, l.token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("refresh lock %s: %w", l.key, err)
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// Release releases the lock if it's still held by this holder and stops its renewal.
+// ErrLockNotHeld is returned if the lock has expired or has been obtained by someone else.
+func (l *Lock) Release(ctx context.Context) error {
+	l.releaseOnce.Do(func() { close(l.released) })
+
+	res, err := releaseScript.Run(ctx, l.redis.conn.client, []string{lockKey(l.key)}, l.token).Int64()
+	if err != nil {
+		return fmt.Errorf("release lock %s: %w", l.key, err)
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// renew refreshes the lock every third of its TTL, so two failed renewals in a row are tolerated
+func (l *Lock) renew() {
+	interval := l.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.released:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := l.Refresh(ctx, l.ttl)
+			cancel()
+
+			switch {
+			case err == nil:
+				renewed = time.Now()
+			case errors.Is(err, ErrLockNotHeld) || time.Since(renewed) >= l.ttl:
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLocker(t *testing.T, cluster bool) (*Locker, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	var r *Redis
+	if cluster {
+		r, err = InitCluster(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	} else {
+		r, err = Init(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	}
+	require.NoError(t, err)
+
+	return NewLocker(r), mr
+}
+
+func TestLocker_Obtain(t *testing.T) {
+	for _, cluster := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cluster=%v", cluster), func(t *testing.T) {
+			locker, mr := newTestLocker(t, cluster)
+			ctx := context.Background()
+
+			lock, err := locker.Obtain(ctx, "deposits", time.Minute, WithoutAutoRenewal())
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), lock.FencingToken())
+			assert.Equal(t, time.Minute, mr.TTL("lock:{deposits}"))
+
+			_, err = locker.Obtain(ctx, "deposits", time.Minute)
+			assert.True(t, errors.Is(err, ErrNotObtained))
+
+			require.NoError(t, lock.Refresh(ctx, 2*time.Minute))
+			assert.Equal(t, 2*time.Minute, mr.TTL("lock:{deposits}"))
+
+			require.NoError(t, lock.Release(ctx))
+			assert.True(t, errors.Is(lock.Release(ctx), ErrLockNotHeld))
+			assert.True(t, errors.Is(lock.Refresh(ctx, time.Minute), ErrLockNotHeld))
+
+			next, err := locker.Obtain(ctx, "deposits", time.Minute, WithoutAutoRenewal())
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), next.FencingToken())
+		})
+	}
+}
+
+func TestLock_ReleaseExpired(t *testing.T) {
+	locker, mr := newTestLocker(t, false)
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "deposits", time.Second, WithoutAutoRenewal())
+	require.NoError(t, err)
+
+	mr.FastForward(2 * time.Second)
+	other, err := locker.Obtain(ctx, "deposits", time.Minute, WithoutAutoRenewal())
+	require.NoError(t, err)
+
+	assert.True(t, errors.Is(lock.Release(ctx), ErrLockNotHeld), "lock of someone else must not be released")
+	assert.True(t, mr.Exists("lock:{deposits}"))
+	assert.Greater(t, other.FencingToken(), lock.FencingToken())
+}
+
+func TestLocker_ObtainWithRetry(t *testing.T) {
+	locker, _ := newTestLocker(t, false)
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "deposits", time.Minute, WithoutAutoRenewal())
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		assert.NoError(t, lock.Release(ctx))
+	}()
+
+	next, err := locker.Obtain(ctx, "deposits", time.Minute, WithRetryStrategy(LinearRetry(10*time.Millisecond, -1)))
+	require.NoError(t, err)
+	require.NoError(t, next.Release(ctx))
+
+	_, err = locker.Obtain(ctx, "deposits", time.Minute)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = locker.Obtain(ctx, "deposits", time.Minute, WithRetryStrategy(LinearRetry(10*time.Millisecond, 3)))
+	assert.True(t, errors.Is(err, ErrNotObtained))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = locker.Obtain(timeoutCtx, "deposits", time.Minute, WithRetryStrategy(LinearRetry(10*time.Millisecond, -1)))
+	assert.True(t, errors.Is(err, ErrNotObtained))
+}
+
+func TestLock_AutoRenewal(t *testing.T) {
+	locker, mr := newTestLocker(t, false)
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "deposits", 150*time.Millisecond)
+	require.NoError(t, err)
+
+	mr.FastForward(100 * time.Millisecond)
+	require.Eventually(t, func() bool {
+		return mr.TTL("lock:{deposits}") == 150*time.Millisecond
+	}, time.Second, 10*time.Millisecond, "lock must be renewed")
+
+	mr.Del("lock:{deposits}")
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock must be lost")
+	}
+}
+
+func TestRetryStrategies(t *testing.T) {
+	delay, retry := NoRetry()(1)
+	assert.Zero(t, delay)
+	assert.False(t, retry)
+
+	exponential := ExponentialRetry(10*time.Millisecond, 50*time.Millisecond, 5)
+	for attempt, expected := range map[int]time.Duration{
+		1: 10 * time.Millisecond,
+		2: 20 * time.Millisecond,
+		3: 40 * time.Millisecond,
+		4: 50 * time.Millisecond,
+	} {
+		delay, retry := exponential(attempt)
+		assert.Equal(t, expected, delay)
+		assert.True(t, retry)
+	}
+	_, retry = exponential(5)
+	assert.False(t, retry)
+}
+
+func TestLockKey(t *testing.T) {
+	assert.Equal(t, "lock:{deposits}", lockKey("deposits"))
+	assert.Equal(t, "lock:{user:1}:balance", lockKey("{user:1}:balance"))
+}
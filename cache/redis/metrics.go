@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+const (
+	pubSubMessagesTotalKey      = "redis_pubsub_messages_total"
+	pubSubHandlerErrorsTotalKey = "redis_pubsub_handler_errors_total"
+	pubSubDisconnectsTotalKey   = "redis_pubsub_disconnects_total"
+	pubSubResubscribesTotalKey  = "redis_pubsub_resubscribes_total"
+
+	labelSubscription = "subscription"
+)
+
+// PubSubMetric records the messages and the connection events of subscriptions.
+// The subscription is the channel or the pattern of pattern subscriptions.
+type PubSubMetric interface {
+	Received(subscription string)
+	Failed(subscription string)
+	Disconnected()
+	Resubscribed()
+}
+
+type pubSubMetric struct {
+	messagesTotal      *prometheus.CounterVec
+	handlerErrorsTotal *prometheus.CounterVec
+	disconnectsTotal   prometheus.Counter
+	resubscribesTotal  prometheus.Counter
+}
+
+func NewPubSubMetric(
+	namespace string,
+	staticLabels prometheus.Labels,
+	reg prometheus.Registerer,
+) PubSubMetric {
+	messagesTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      pubSubMessagesTotalKey,
+		Help:      "Total number of received Pub/Sub messages.",
+	}, []string{labelSubscription})
+
+	handlerErrorsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      pubSubHandlerErrorsTotalKey,
+		Help:      "Total number of Pub/Sub messages failed by handlers.",
+	}, []string{labelSubscription})
+
+	disconnectsTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      pubSubDisconnectsTotalKey,
+		Help:      "Total number of lost Pub/Sub connections.",
+	})
+
+	resubscribesTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      pubSubResubscribesTotalKey,
+		Help:      "Total number of restored Pub/Sub subscriptions.",
+	})
+
+	metrics.Register(staticLabels, reg, messagesTotal, handlerErrorsTotal, disconnectsTotal, resubscribesTotal)
+
+	return &pubSubMetric{
+		messagesTotal:      messagesTotal,
+		handlerErrorsTotal: handlerErrorsTotal,
+		disconnectsTotal:   disconnectsTotal,
+		resubscribesTotal:  resubscribesTotal,
+	}
+}
+
+func (m *pubSubMetric) Received(subscription string) {
+	m.messagesTotal.WithLabelValues(subscription).Inc()
+}
+
+func (m *pubSubMetric) Failed(subscription string) {
+	m.handlerErrorsTotal.WithLabelValues(subscription).Inc()
+}
+
+func (m *pubSubMetric) Disconnected() {
+	m.disconnectsTotal.Inc()
+}
+
+func (m *pubSubMetric) Resubscribed() {
+	m.resubscribesTotal.Inc()
+}
+
+type NullablePubSubMetric struct{}
+
+func (NullablePubSubMetric) Received(_ string) {}
+func (NullablePubSubMetric) Failed(_ string)   {}
+func (NullablePubSubMetric) Disconnected()     {}
+func (NullablePubSubMetric) Resubscribed()     {}
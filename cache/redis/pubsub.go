@@ -0,0 +1,267 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPubSubBufferSize      = 100
+	defaultPubSubResubscribeWait = time.Second
+)
+
+// PubSubMessage is a message received by a subscription.
+type PubSubMessage struct {
+	Channel string
+	// Pattern is the matched pattern of pattern subscriptions.
+	Pattern string
+	Payload []byte
+}
+
+// PubSubHandler handles the messages of a subscription, errors are logged and counted.
+type PubSubHandler func(ctx context.Context, msg PubSubMessage) error
+
+type subscribeMode int
+
+const (
+	subscribeChannels subscribeMode = iota
+	subscribePatterns
+	subscribeSharded
+)
+
+type SubscribeOption func(o *subscribeOptions)
+
+type subscribeOptions struct {
+	mode            subscribeMode
+	workers         int
+	bufferSize      int
+	resubscribeWait time.Duration
+	resubscribeHook func()
+	metric          PubSubMetric
+}
+
+// WithPatterns subscribes to the channels matching the glob-style patterns, e.g. "prices:*".
+func WithPatterns() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.mode = subscribePatterns
+	}
+}
+
+// WithShardedChannels uses the sharded Pub/Sub of Redis 7, messages are propagated only within the shard
+// of the channel instead of the whole cluster. In cluster mode all channels must belong to the same slot,
+// e.g. by sharing a hash tag. Messages must be published by SPublish.
+func WithShardedChannels() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.mode = subscribeSharded
+	}
+}
+
+// WithHandlerWorkers sets the number of concurrent handlers, 1 by default.
+// Messages are handled in the order of receipt only by a single worker.
+func WithHandlerWorkers(workers int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = workers
+	}
+}
+
+// WithBufferSize sets the number of received messages waiting for the handlers, 100 by default.
+// Receiving is paused while the buffer is full.
+func WithBufferSize(size int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithResubscribeWait sets the wait before reconnecting after the connection is lost, 1 second by default.
+func WithResubscribeWait(wait time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.resubscribeWait = wait
+	}
+}
+
+// WithResubscribeHook sets the function called after the subscription is restored.
+// Messages published while the connection was lost are never received, e.g. caches should be purged by the hook.
+func WithResubscribeHook(hook func()) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.resubscribeHook = hook
+	}
+}
+
+func WithPubSubMetric(metric PubSubMetric) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.metric = metric
+	}
+}
+
+// Subscription receives messages until the context of Subscribe is done.
+type Subscription struct {
+	pubsub  *redis.PubSub
+	handler PubSubHandler
+	options *subscribeOptions
+	done    chan struct{}
+}
+
+// Done is closed after the context of Subscribe is done and the running handlers have returned.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Publish sends the message to the subscribers of the channel.
+func (r *Redis) Publish(ctx context.Context, channel string, message []byte) error {
+	if err := r.conn.client.Publish(ctx, channel, message).Err(); err != nil {
+		return fmt.Errorf("publish to %s: %w", channel, err)
+	}
+
+	return nil
+}
+
+// SPublish sends the message to the subscribers of the sharded channel, see WithShardedChannels.
+func (r *Redis) SPublish(ctx context.Context, channel string, message []byte) error {
+	if err := r.conn.client.SPublish(ctx, channel, message).Err(); err != nil {
+		return fmt.Errorf("publish to sharded %s: %w", channel, err)
+	}
+
+	return nil
+}
+
+// Subscribe runs the handler for the messages of the channels until the context is done.
+// The subscription is confirmed before returning, so the messages published afterwards are received.
+// If the connection is lost, the subscription is restored once Redis is reachable again.
+func (r *Redis) Subscribe(ctx context.Context, channels []string, handler PubSubHandler, opts ...SubscribeOption) (*Subscription, error) {
+	options := &subscribeOptions{
+		workers:         1,
+		bufferSize:      defaultPubSubBufferSize,
+		resubscribeWait: defaultPubSubResubscribeWait,
+		metric:          &NullablePubSubMetric{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if len(channels) == 0 || options.workers < 1 || options.bufferSize < 0 {
+		return nil, fmt.Errorf("invalid subscription: %d channels, %d workers, buffer %d",
+			len(channels), options.workers, options.bufferSize)
+	}
+
+	var pubsub *redis.PubSub
+	switch options.mode {
+	case subscribePatterns:
+		pubsub = r.conn.client.PSubscribe(ctx, channels...)
+	case subscribeSharded:
+		pubsub = r.conn.client.SSubscribe(ctx, channels...)
+	default:
+		pubsub = r.conn.client.Subscribe(ctx, channels...)
+	}
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("subscribe to %v: %w", channels, err)
+	}
+
+	s := &Subscription{
+		pubsub:  pubsub,
+		handler: handler,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	go s.run(ctx)
+
+	return s, nil
+}
+
+func (s *Subscription) run(ctx context.Context) {
+	defer close(s.done)
+
+	// closing unblocks the receiving
+	go func() {
+		<-ctx.Done()
+		_ = s.pubsub.Close()
+	}()
+
+	messages := make(chan PubSubMessage, s.options.bufferSize)
+	var wg sync.WaitGroup
+	for w := 0; w < s.options.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, messages)
+		}()
+	}
+
+	s.receive(ctx, messages)
+
+	close(messages)
+	wg.Wait()
+}
+
+// receive passes the messages to the handlers. On errors, the connection is dropped and
+// the next Receive reconnects and restores all subscriptions of the connection.
+func (s *Subscription) receive(ctx context.Context, messages chan<- PubSubMessage) {
+	disconnected := false
+	for {
+		received, err := s.pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.WithError(err).Warn("Redis subscription lost")
+			s.options.metric.Disconnected()
+			disconnected = true
+
+			timer := time.NewTimer(s.options.resubscribeWait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+
+		switch msg := received.(type) {
+		case *redis.Subscription:
+			if disconnected {
+				disconnected = false
+				log.Infof("Redis subscription to %s restored", msg.Channel)
+				s.options.metric.Resubscribed()
+				if s.options.resubscribeHook != nil {
+					s.options.resubscribeHook()
+				}
+			}
+		case *redis.Message:
+			select {
+			case <-ctx.Done():
+				return
+			case messages <- PubSubMessage{Channel: msg.Channel, Pattern: msg.Pattern, Payload: []byte(msg.Payload)}:
+			}
+		}
+	}
+}
+
+func (s *Subscription) handle(ctx context.Context, messages <-chan PubSubMessage) {
+	for msg := range messages {
+		if ctx.Err() != nil {
+			// the subscription is closed, buffered messages are dropped
+			continue
+		}
+
+		subscription := msg.Channel
+		if msg.Pattern != "" {
+			subscription = msg.Pattern
+		}
+		s.options.metric.Received(subscription)
+
+		if err := s.handler(ctx, msg); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.WithError(err).Errorf("Handle message of %s", msg.Channel)
+			}
+			s.options.metric.Failed(subscription)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPubSubMetric struct {
+	mu                        sync.Mutex
+	received, failed          map[string]int
+	disconnects, resubscribes int
+}
+
+func newRecordingPubSubMetric() *recordingPubSubMetric {
+	return &recordingPubSubMetric{received: map[string]int{}, failed: map[string]int{}}
+}
+
+func (m *recordingPubSubMetric) Received(subscription string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received[subscription]++
+}
+
+func (m *recordingPubSubMetric) Failed(subscription string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[subscription]++
+}
+
+func (m *recordingPubSubMetric) Disconnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnects++
+}
+
+func (m *recordingPubSubMetric) Resubscribed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resubscribes++
+}
+
+func (m *recordingPubSubMetric) snapshot() (received, failed map[string]int, disconnects, resubscribes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	received, failed = map[string]int{}, map[string]int{}
+	for k, v := range m.received {
+		received[k] = v
+	}
+	for k, v := range m.failed {
+		failed[k] = v
+	}
+	return received, failed, m.disconnects, m.resubscribes
+}
+
+// collector gathers the payloads received by a handler
+type collector struct {
+	mu       sync.Mutex
+	payloads []string
+}
+
+func (c *collector) handle(_ context.Context, msg PubSubMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payloads = append(c.payloads, string(msg.Payload))
+	return nil
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.payloads...)
+}
+
+func TestRedis_PublishSubscribe(t *testing.T) {
+	redisInitFns := []redisInitFn{redisInit, redisClusterInit}
+	for _, redisInit := range redisInitFns {
+		t.Run("", func(t *testing.T) {
+			r, err := redisInit(t)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			c := &collector{}
+			sub, err := r.Subscribe(ctx, []string{"events", "alerts"}, c.handle)
+			require.NoError(t, err)
+
+			require.NoError(t, r.Publish(context.Background(), "events", []byte("first")))
+			require.NoError(t, r.Publish(context.Background(), "alerts", []byte("second")))
+			require.NoError(t, r.Publish(context.Background(), "other", []byte("ignored")))
+			require.Eventually(t, func() bool {
+				return len(c.received()) == 2
+			}, time.Second, 10*time.Millisecond)
+			assert.Equal(t, []string{"first", "second"}, c.received(), "single worker must keep the order")
+
+			cancel()
+			select {
+			case <-sub.Done():
+			case <-time.After(time.Second):
+				t.Fatal("subscription must be closed")
+			}
+		})
+	}
+}
+
+func TestRedis_SubscribePatterns(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metric := newRecordingPubSubMetric()
+	var handled int32
+	_, err = r.Subscribe(ctx, []string{"prices:*"}, func(_ context.Context, msg PubSubMessage) error {
+		atomic.AddInt32(&handled, 1)
+		assert.Equal(t, "prices:*", msg.Pattern)
+		if msg.Channel == "prices:doge" {
+			return errors.New("unknown asset")
+		}
+		return nil
+	}, WithPatterns(), WithHandlerWorkers(4), WithPubSubMetric(metric))
+	require.NoError(t, err)
+
+	for _, channel := range []string{"prices:btc", "prices:eth", "prices:doge", "volumes:btc"} {
+		require.NoError(t, r.Publish(ctx, channel, []byte("1")))
+	}
+
+	require.Eventually(t, func() bool {
+		received, _, _, _ := metric.snapshot()
+		return received["prices:*"] == 3
+	}, time.Second, 10*time.Millisecond)
+
+	_, failed, _, _ := metric.snapshot()
+	assert.Equal(t, 1, failed["prices:*"])
+	assert.Equal(t, int32(3), atomic.LoadInt32(&handled))
+}
+
+func TestRedis_SubscribeRestoresSubscription(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	r, err := Init(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metric := newRecordingPubSubMetric()
+	var hooks int32
+	c := &collector{}
+	_, err = r.Subscribe(ctx, []string{"events"}, c.handle,
+		WithResubscribeWait(10*time.Millisecond),
+		WithResubscribeHook(func() { atomic.AddInt32(&hooks, 1) }),
+		WithPubSubMetric(metric))
+	require.NoError(t, err)
+
+	mr.Close()
+	require.Eventually(t, func() bool {
+		_, _, disconnects, _ := metric.snapshot()
+		return disconnects > 0
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, mr.Restart())
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&hooks) == 1
+	}, 5*time.Second, 10*time.Millisecond, "subscription must be restored")
+
+	require.NoError(t, r.Publish(ctx, "events", []byte("after restart")))
+	require.Eventually(t, func() bool {
+		return len(c.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, _, _, resubscribes := metric.snapshot()
+	assert.Equal(t, 1, resubscribes)
+}
+
+func TestRedis_SubscribeInvalid(t *testing.T) {
+	r, err := redisInit(t)
+	require.NoError(t, err)
+
+	c := &collector{}
+	_, err = r.Subscribe(context.Background(), nil, c.handle)
+	assert.Error(t, err)
+
+	_, err = r.Subscribe(context.Background(), []string{"events"}, c.handle, WithHandlerWorkers(0))
+	assert.Error(t, err)
+}
+
+func TestNewPubSubMetric(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metric := NewPubSubMetric("test", prometheus.Labels{"service": "prices"}, reg)
+
+	metric.Received("events")
+	metric.Failed("events")
+	metric.Disconnected()
+	metric.Resubscribed()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	assert.True(t, names["test_redis_pubsub_messages_total"])
+	assert.True(t, names["test_redis_pubsub_handler_errors_total"])
+	assert.True(t, names["test_redis_pubsub_disconnects_total"])
+	assert.True(t, names["test_redis_pubsub_resubscribes_total"])
+}
@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowScript keeps a sorted set of the request times within the window, scores are microseconds.
+// It returns allowed, remaining, reset after and retry after in microseconds.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[2])
+local count = redis.call("ZCARD", KEYS[1])
+
+local allowed = 0
+if count + n <= limit then
+	for i = 1, n do
+		redis.call("ZADD", KEYS[1], ARGV[1], ARGV[6] .. ":" .. i)
+	end
+	count = count + n
+	allowed = 1
+end
+
+local retry_after = 0
+if allowed == 0 then
+	local oldest = redis.call("ZRANGE", KEYS[1], count + n - limit - 1, count + n - limit - 1, "WITHSCORES")
+	retry_after = tonumber(oldest[2]) + window - now
+end
+
+local reset_after = 0
+if count > 0 then
+	local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+	reset_after = tonumber(newest[2]) + window - now
+	redis.call("PEXPIRE", KEYS[1], math.ceil(reset_after / 1000))
+end
+
+return {allowed, limit - count, reset_after, retry_after}
+`)
+
+// gcraScript stores the theoretical arrival time of the next request in microseconds.
+// It returns allowed, remaining, reset after and retry after in microseconds.
+var gcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local tolerance = emission * burst
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + emission * n
+local diff = now - (new_tat - tolerance)
+if diff < 0 then
+	local remaining = math.floor((now - (tat - tolerance)) / emission)
+	return {0, remaining, tat - now, -diff}
+end
+
+redis.call("SET", KEYS[1], string.format("%.0f", new_tat), "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor(diff / emission), new_tat - now, 0}
+`)
+
+type RateLimitAlgorithm int
+
+const (
+	// SlidingWindow allows Rate requests within any Period. It stores every request of the period, so it suits low rates.
+	SlidingWindow RateLimitAlgorithm = iota
+	// GCRA spreads requests evenly over the period allowing bursts up to Burst requests. It stores a single value per key.
+	GCRA
+)
+
+type RateLimit struct {
+	// Rate is the number of requests allowed per Period.
+	Rate   int
+	Period time.Duration
+	// Burst is the maximum number of requests at once, it's only used by GCRA and equal to Rate if it's zero.
+	Burst int
+}
+
+func PerSecond(rate int) RateLimit {
+	return RateLimit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) RateLimit {
+	return RateLimit{Rate: rate, Period: time.Minute}
+}
+
+func PerHour(rate int) RateLimit {
+	return RateLimit{Rate: rate, Period: time.Hour}
+}
+
+// RateLimitResult is the decision of the limiter for a request.
+type RateLimitResult struct {
+	Allowed bool
+	// Limit is the maximum number of requests at once.
+	Limit int
+	// Remaining is the number of requests allowed right after this one.
+	Remaining int
+	// ResetAfter is the time until the whole limit is available again.
+	ResetAfter time.Duration
+	// RetryAfter is the time until the denied request is allowed, zero for allowed requests.
+	RetryAfter time.Duration
+}
+
+// RateLimiter limits the rate of requests by a key across all replicas of the service.
+// Every decision is made atomically by a Lua script. The time of the caller is used, so replicas need synchronized clocks.
+type RateLimiter struct {
+	redis     *Redis
+	algorithm RateLimitAlgorithm
+	limit     RateLimit
+	keyPrefix string
+	now       func() time.Time
+}
+
+// NewRateLimiter creates a limiter storing its state by the keys prefixed with the key prefix.
+func NewRateLimiter(r *Redis, algorithm RateLimitAlgorithm, limit RateLimit, keyPrefix string) (*RateLimiter, error) {
+	if limit.Rate <= 0 || limit.Period <= 0 || limit.Burst < 0 {
+		return nil, fmt.Errorf("invalid rate limit: %d per %s, burst %d", limit.Rate, limit.Period, limit.Burst)
+	}
+	if limit.Burst == 0 {
+		limit.Burst = limit.Rate
+	}
+
+	return &RateLimiter{
+		redis:     r,
+		algorithm: algorithm,
+		limit:     limit,
+		keyPrefix: keyPrefix,
+		now:       time.Now,
+	}, nil
+}
+
+// Allow is AllowN for a single request.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN decides whether n requests by the key are allowed and counts them if they are.
+func (l *RateLimiter) AllowN(ctx context.Context, key string, n int) (RateLimitResult, error) {
+	limit := l.limit.Rate
+	if l.algorithm == GCRA {
+		limit = l.limit.Burst
+	}
+	if n <= 0 || n > limit {
+		return RateLimitResult{}, fmt.Errorf("invalid number of requests %d for the limit %d", n, limit)
+	}
+
+	now := l.now().UnixMicro()
+	keys := []string{l.keyPrefix + key}
+
+	var cmd *redis.Cmd
+	switch l.algorithm {
+	case SlidingWindow:
+		member, err := newRateLimitMember()
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		window := l.limit.Period.Microseconds()
+		cmd = slidingWindowScript.Run(ctx, l.redis.conn.client, keys,
+			strconv.FormatInt(now, 10), strconv.FormatInt(now-window, 10), window, limit, n, member)
+	case GCRA:
+		emission := l.limit.Period.Microseconds() / int64(l.limit.Rate)
+		cmd = gcraScript.Run(ctx, l.redis.conn.client, keys, now, emission, limit, n)
+	default:
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm: %d", l.algorithm)
+	}
+
+	values, err := cmd.Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("rate limit %s: %w", key, err)
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("rate limit %s: unexpected result %v", key, values)
+	}
+
+	remaining := int(values[1])
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  remaining,
+		ResetAfter: time.Duration(values[2]) * time.Microsecond,
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+func newRateLimitMember() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate rate limit member: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,152 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRateLimiter(
+	t *testing.T,
+	cluster bool,
+	algorithm RateLimitAlgorithm,
+	limit RateLimit,
+) (*RateLimiter, *miniredis.Miniredis, *time.Time) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	var r *Redis
+	if cluster {
+		r, err = InitCluster(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	} else {
+		r, err = Init(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	}
+	require.NoError(t, err)
+
+	limiter, err := NewRateLimiter(r, algorithm, limit, "rate_limit:")
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	return limiter, mr, &now
+}
+
+func TestRateLimiter_SlidingWindow(t *testing.T) {
+	for _, cluster := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cluster=%v", cluster), func(t *testing.T) {
+			limiter, mr, now := newTestRateLimiter(t, cluster, SlidingWindow, PerMinute(3))
+			ctx := context.Background()
+
+			for i := 0; i < 3; i++ {
+				res, err := limiter.Allow(ctx, "1.2.3.4")
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+				assert.Equal(t, 3, res.Limit)
+				assert.Equal(t, 2-i, res.Remaining)
+				assert.Equal(t, time.Minute, res.ResetAfter)
+				assert.Zero(t, res.RetryAfter)
+				*now = now.Add(10 * time.Second)
+			}
+			assert.Equal(t, time.Minute, mr.TTL("rate_limit:1.2.3.4"))
+
+			res, err := limiter.Allow(ctx, "1.2.3.4")
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Zero(t, res.Remaining)
+			assert.Equal(t, 30*time.Second, res.RetryAfter, "the oldest request leaves the window in 30s")
+			assert.Equal(t, 50*time.Second, res.ResetAfter)
+
+			res, err = limiter.Allow(ctx, "5.6.7.8")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed, "keys must be limited separately")
+
+			*now = now.Add(30 * time.Second)
+			res, err = limiter.Allow(ctx, "1.2.3.4")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Zero(t, res.Remaining)
+		})
+	}
+}
+
+func TestRateLimiter_SlidingWindowAllowN(t *testing.T) {
+	limiter, _, now := newTestRateLimiter(t, false, SlidingWindow, PerSecond(5))
+	ctx := context.Background()
+
+	res, err := limiter.AllowN(ctx, "key", 2)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 3, res.Remaining)
+
+	*now = now.Add(500 * time.Millisecond)
+	res, err = limiter.AllowN(ctx, "key", 3)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Zero(t, res.Remaining)
+
+	res, err = limiter.AllowN(ctx, "key", 3)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter, "the third oldest request leaves the window in 1s")
+
+	_, err = limiter.AllowN(ctx, "key", 6)
+	assert.Error(t, err)
+}
+
+func TestRateLimiter_GCRA(t *testing.T) {
+	for _, cluster := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cluster=%v", cluster), func(t *testing.T) {
+			limiter, mr, now := newTestRateLimiter(t, cluster, GCRA, RateLimit{Rate: 10, Period: time.Second, Burst: 2})
+			ctx := context.Background()
+
+			res, err := limiter.Allow(ctx, "key")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 2, res.Limit)
+			assert.Equal(t, 1, res.Remaining)
+			assert.Equal(t, 100*time.Millisecond, res.ResetAfter)
+
+			res, err = limiter.Allow(ctx, "key")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Zero(t, res.Remaining)
+			assert.Equal(t, 200*time.Millisecond, res.ResetAfter)
+			assert.Equal(t, 200*time.Millisecond, mr.TTL("rate_limit:key"))
+
+			res, err = limiter.Allow(ctx, "key")
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Zero(t, res.Remaining)
+			assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+
+			*now = now.Add(100 * time.Millisecond)
+			res, err = limiter.Allow(ctx, "key")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed, "requests must be allowed at the emission rate")
+			assert.Zero(t, res.Remaining)
+
+			*now = now.Add(time.Second)
+			res, err = limiter.AllowN(ctx, "key", 2)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed, "the whole burst must be available after the idle time")
+		})
+	}
+}
+
+func TestNewRateLimiter_InvalidLimit(t *testing.T) {
+	for _, limit := range []RateLimit{
+		{Rate: 0, Period: time.Second},
+		{Rate: 1, Period: 0},
+		{Rate: 1, Period: time.Second, Burst: -1},
+	} {
+		_, err := NewRateLimiter(nil, GCRA, limit, "")
+		assert.Error(t, err)
+	}
+}
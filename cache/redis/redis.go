@@ -3,7 +3,6 @@ package redis
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -16,8 +15,17 @@ var ErrNotFound = fmt.Errorf("not found")
 const healthCheckTimeout = 2 * time.Second
 
 type Redis struct {
-	client    redisClient
+	// conn is shared with the copies made by WithCodec, so Reconnect replaces their client too
+	conn      *connection
 	isCluster bool
+
+	// codec encodes values, they're written as plain JSON if it's nil
+	codec        Codec
+	codecOptions *codecOptions
+}
+
+type connection struct {
+	client redisClient
 }
 
 type Option func(o *redis.Options)
@@ -77,7 +85,7 @@ func Init(ctx context.Context, host string, opts ...Option) (*Redis, error) {
 		return nil, err
 	}
 
-	return &Redis{client: client}, nil
+	return &Redis{conn: &connection{client: client}}, nil
 }
 
 func InitClusterWithTLS(url string, secure bool, opts ...ClusterOption) (*Redis, error) {
@@ -120,18 +128,18 @@ func InitCluster(ctx context.Context, redisURL string, opts ...ClusterOption) (*
 		return nil, err
 	}
 
-	return &Redis{client: client, isCluster: true}, nil
+	return &Redis{conn: &connection{client: client}, isCluster: true}, nil
 }
 
 func (r *Redis) Get(ctx context.Context, key string, receiver interface{}) error {
-	cmd := r.client.Get(ctx, key)
+	cmd := r.conn.client.Get(ctx, key)
 	if errors.Is(cmd.Err(), redis.Nil) {
 		return ErrNotFound
 	} else if cmd.Err() != nil {
 		return cmd.Err()
 	}
 
-	err := json.Unmarshal([]byte(cmd.Val()), receiver)
+	err := r.decode([]byte(cmd.Val()), receiver)
 	if err != nil {
 		return err
 	}
@@ -140,7 +148,7 @@ func (r *Redis) Get(ctx context.Context, key string, receiver interface{}) error
 }
 
 func (r *Redis) GetBytes(ctx context.Context, key string) ([]byte, error) {
-	cmd := r.client.Get(ctx, key)
+	cmd := r.conn.client.Get(ctx, key)
 	if errors.Is(cmd.Err(), redis.Nil) {
 		return nil, ErrNotFound
 	} else if cmd.Err() != nil {
@@ -153,7 +161,7 @@ func (r *Redis) GetBytes(ctx context.Context, key string) ([]byte, error) {
 // MGet returns slice with length == len(key)
 // Resulting slice's item is nil if there is no value in cache
 func (r *Redis) MGet(ctx context.Context, key ...string) ([][]byte, error) {
-	cmd := r.client.MGet(ctx, key...)
+	cmd := r.conn.client.MGet(ctx, key...)
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
@@ -168,9 +176,37 @@ func (r *Redis) MGet(ctx context.Context, key ...string) ([][]byte, error) {
 	return result, nil
 }
 
+// GetManyBytes returns the values of the keys like MGet, but reads them by pipelined GETs,
+// so the keys may belong to different slots of a cluster.
+func (r *Redis) GetManyBytes(ctx context.Context, keys ...string) ([][]byte, error) {
+	p := r.conn.client.Pipeline()
+
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = p.Get(ctx, key)
+	}
+
+	// Exec returns redis.Nil if any of the keys is missing
+	if _, err := p.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	result := make([][]byte, len(keys))
+	for i, cmd := range cmds {
+		if errors.Is(cmd.Err(), redis.Nil) {
+			continue
+		} else if cmd.Err() != nil {
+			return nil, cmd.Err()
+		}
+		result[i] = []byte(cmd.Val())
+	}
+
+	return result, nil
+}
+
 // Scan return keys by pattern
 func (r *Redis) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, error) {
-	iter := r.client.Scan(ctx, cursor, match, count).Iterator()
+	iter := r.conn.client.Scan(ctx, cursor, match, count).Iterator()
 
 	res := make([]string, 0)
 	for iter.Next(ctx) {
@@ -181,12 +217,12 @@ func (r *Redis) Scan(ctx context.Context, cursor uint64, match string, count int
 }
 
 func (r *Redis) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	data, err := json.Marshal(value)
+	data, err := r.encode(value)
 	if err != nil {
 		return err
 	}
 
-	cmd := r.client.Set(ctx, key, data, expiration)
+	cmd := r.conn.client.Set(ctx, key, data, expiration)
 	if cmd.Err() != nil {
 		return cmd.Err()
 	}
@@ -195,7 +231,7 @@ func (r *Redis) Set(ctx context.Context, key string, value interface{}, expirati
 }
 
 func (r *Redis) SetBytes(ctx context.Context, key string, value []byte, expiration time.Duration) error {
-	cmd := r.client.Set(ctx, key, value, expiration)
+	cmd := r.conn.client.Set(ctx, key, value, expiration)
 	if cmd.Err() != nil {
 		return cmd.Err()
 	}
@@ -204,10 +240,10 @@ func (r *Redis) SetBytes(ctx context.Context, key string, value []byte, expirati
 }
 
 func (r *Redis) MSet(ctx context.Context, pairs map[string]interface{}, expiration time.Duration) error {
-	p := r.client.Pipeline()
+	p := r.conn.client.Pipeline()
 
 	for k, v := range pairs {
-		data, err := json.Marshal(v)
+		data, err := r.encode(v)
 		if err != nil {
 			return err
 		}
@@ -226,7 +262,7 @@ func (r *Redis) MSet(ctx context.Context, pairs map[string]interface{}, expirati
 }
 
 func (r *Redis) Delete(ctx context.Context, key string) error {
-	cmd := r.client.Del(ctx, key)
+	cmd := r.conn.client.Del(ctx, key)
 	if cmd.Err() != nil {
 		return cmd.Err()
 	}
@@ -235,11 +271,11 @@ func (r *Redis) Delete(ctx context.Context, key string) error {
 }
 
 func (r *Redis) Watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
-	return r.client.Watch(ctx, fn, keys...)
+	return r.conn.client.Watch(ctx, fn, keys...)
 }
 
 func (r *Redis) IsAvailable(ctx context.Context) bool {
-	return r.client.Ping(ctx).Err() == nil
+	return r.conn.client.Ping(ctx).Err() == nil
 }
 
 func (r *Redis) Reconnect(ctx context.Context, host string) error {
@@ -257,8 +293,8 @@ func (r *Redis) Reconnect(ctx context.Context, host string) error {
 		return err
 	}
 
-	r.client = client
-	if err := r.client.Ping(ctx).Err(); err != nil {
+	r.conn.client = client
+	if err := r.conn.client.Ping(ctx).Err(); err != nil {
 		return err
 	}
 
@@ -266,12 +302,12 @@ func (r *Redis) Reconnect(ctx context.Context, host string) error {
 }
 
 func (r *Redis) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
-	data, err := json.Marshal(value)
+	data, err := r.encode(value)
 	if err != nil {
 		return false, err
 	}
 
-	cmd := r.client.SetNX(ctx, key, data, expiration)
+	cmd := r.conn.client.SetNX(ctx, key, data, expiration)
 	if cmd.Err() != nil {
 		return false, cmd.Err()
 	}
@@ -280,12 +316,12 @@ func (r *Redis) SetNX(ctx context.Context, key string, value interface{}, expira
 }
 
 func (r *Redis) SetXX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
-	data, err := json.Marshal(value)
+	data, err := r.encode(value)
 	if err != nil {
 		return false, err
 	}
 
-	cmd := r.client.SetXX(ctx, key, data, expiration)
+	cmd := r.conn.client.SetXX(ctx, key, data, expiration)
 	if cmd.Err() != nil {
 		return false, cmd.Err()
 	}
@@ -293,6 +329,22 @@ func (r *Redis) SetXX(ctx context.Context, key string, value interface{}, expira
 	return cmd.Val(), nil
 }
 
+// CompareAndDelete deletes the key only if it holds the value, e.g. to release a lease taken with SetNX
+// without removing the lease of another owner once the own one has expired. It returns whether the key was deleted.
+func (r *Redis) CompareAndDelete(ctx context.Context, key string, value interface{}) (bool, error) {
+	data, err := r.encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := releaseScript.Run(ctx, r.conn.client, []string{key}, data).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return deleted == 1, nil
+}
+
 func (r *Redis) reconnectCluster(ctx context.Context, redisURL string) error {
 	options, err := redis.ParseClusterURL(redisURL)
 	if err != nil {
@@ -304,8 +356,8 @@ func (r *Redis) reconnectCluster(ctx context.Context, redisURL string) error {
 		return err
 	}
 
-	r.client = client
-	if err := r.client.Ping(ctx).Err(); err != nil {
+	r.conn.client = client
+	if err := r.conn.client.Ping(ctx).Err(); err != nil {
 		return err
 	}
 
@@ -313,7 +365,7 @@ func (r *Redis) reconnectCluster(ctx context.Context, redisURL string) error {
 }
 
 func (r *Redis) Close() error {
-	return r.client.Close()
+	return r.conn.client.Close()
 }
 
 func (r *Redis) HealthCheck() error {
@@ -75,7 +75,7 @@ func TestRedis_Set(t *testing.T) {
 			assert.Nil(t, err)
 			assert.Equal(t, testData, newValue)
 
-			ttl := r.client.TTL(context.TODO(), "test")
+			ttl := r.conn.client.TTL(context.TODO(), "test")
 			assert.Equal(t, time.Second, ttl.Val())
 		})
 	}
@@ -155,14 +155,36 @@ func TestRedis_MSet_MGet(t *testing.T) {
 				assert.Equal(t, testData[key], data)
 			}
 
-			ttl := r.client.TTL(context.TODO(), "test1")
+			ttl := r.conn.client.TTL(context.TODO(), "test1")
 			assert.Equal(t, time.Second, ttl.Val())
-			ttl = r.client.TTL(context.TODO(), "test2")
+			ttl = r.conn.client.TTL(context.TODO(), "test2")
 			assert.Equal(t, time.Second, ttl.Val())
 		})
 	}
 }
 
+func TestRedis_GetManyBytes(t *testing.T) {
+	redisInitFns := []redisInitFn{redisInit, redisClusterInit}
+	for _, redisInit := range redisInitFns {
+		t.Run("", func(t *testing.T) {
+			r, err := redisInit(t)
+			assert.Nil(t, err)
+
+			// the keys hash to different cluster slots, MGET would fail with CROSSSLOT on a real cluster
+			assert.NoError(t, r.SetBytes(context.TODO(), "token:btc", []byte("1"), time.Minute))
+			assert.NoError(t, r.SetBytes(context.TODO(), "token:eth", []byte("2"), time.Minute))
+
+			values, err := r.GetManyBytes(context.TODO(), "token:btc", "token:doge", "token:eth")
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("1"), nil, []byte("2")}, values)
+
+			values, err = r.GetManyBytes(context.TODO(), "token:doge")
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{nil}, values)
+		})
+	}
+}
+
 func TestRedis_Get(t *testing.T) {
 	redisInitFns := []redisInitFn{redisInit, redisClusterInit}
 	for _, redisInit := range redisInitFns {
@@ -184,7 +206,7 @@ func TestRedis_Get(t *testing.T) {
 			assert.Nil(t, err)
 			assert.Equal(t, testData, newValue)
 
-			ttl := r.client.TTL(context.TODO(), "test")
+			ttl := r.conn.client.TTL(context.TODO(), "test")
 			assert.Equal(t, time.Second, ttl.Val())
 
 			var empty interface{}
@@ -330,6 +352,31 @@ func TestRedis_SetXX(t *testing.T) {
 	}
 }
 
+func TestRedis_CompareAndDelete(t *testing.T) {
+	redisInitFns := []redisInitFn{redisInit, redisClusterInit}
+	for _, redisInit := range redisInitFns {
+		t.Run("", func(t *testing.T) {
+			r, err := redisInit(t)
+			require.NoError(t, err)
+
+			ok, err := r.SetNX(context.TODO(), "lease", "owner-1", time.Minute)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			deleted, err := r.CompareAndDelete(context.TODO(), "lease", "owner-2")
+			assert.NoError(t, err)
+			assert.False(t, deleted)
+
+			deleted, err = r.CompareAndDelete(context.TODO(), "lease", "owner-1")
+			assert.NoError(t, err)
+			assert.True(t, deleted)
+
+			var owner string
+			assert.ErrorIs(t, r.Get(context.TODO(), "lease", &owner), ErrNotFound)
+		})
+	}
+}
+
 func redisInit(t *testing.T) (*Redis, error) {
 	mr, err := miniredis.Run()
 	assert.NotNil(t, mr)
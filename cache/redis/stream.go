@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamMessage is an entry of a stream, values are strings when they're read.
+type StreamMessage struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// PendingMessage is a message delivered to a consumer of a group and not acknowledged yet.
+type PendingMessage struct {
+	ID       string
+	Consumer string
+	// Idle is the time since the last delivery of the message.
+	Idle time.Duration
+	// Deliveries is the number of times the message has been delivered.
+	Deliveries int64
+}
+
+// XAdd appends the message to the stream and returns its ID. A positive maxLen trims the stream
+// to approximately maxLen messages, which is much cheaper than the exact trimming.
+func (r *Redis) XAdd(ctx context.Context, stream string, values map[string]interface{}, maxLen int64) (string, error) {
+	args := &redis.XAddArgs{Stream: stream, Values: values}
+	if maxLen > 0 {
+		args.MaxLen = maxLen
+		args.Approx = true
+	}
+
+	return r.conn.client.XAdd(ctx, args).Result()
+}
+
+func (r *Redis) XLen(ctx context.Context, stream string) (int64, error) {
+	return r.conn.client.XLen(ctx, stream).Result()
+}
+
+// XGroupCreate creates the consumer group and the stream if they don't exist.
+// The group starts from the message ID start, "0" is the whole stream, "$" is new messages only.
+func (r *Redis) XGroupCreate(ctx context.Context, stream, group, start string) error {
+	err := r.conn.client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+// XReadGroup returns up to count new messages of the stream for the consumer of the group,
+// waiting up to block for them. It returns no messages once block is over.
+func (r *Redis) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]StreamMessage, error) {
+	streams, err := r.conn.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var messages []StreamMessage
+	for _, s := range streams {
+		messages = append(messages, streamMessages(s.Messages)...)
+	}
+
+	return messages, nil
+}
+
+func (r *Redis) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	return r.conn.client.XAck(ctx, stream, group, ids...).Err()
+}
+
+// XAutoClaim transfers up to count messages pending longer than minIdle to the consumer, e.g. from crashed consumers.
+// The scan starts from the message ID start, the returned ID is the start of the next scan, "0-0" when it's complete.
+func (r *Redis) XAutoClaim(
+	ctx context.Context,
+	stream, group, consumer string,
+	minIdle time.Duration,
+	start string,
+	count int64,
+) ([]StreamMessage, string, error) {
+	messages, next, err := r.conn.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    start,
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return streamMessages(messages), next, nil
+}
+
+// XPending returns up to count pending messages of the group from the oldest one.
+func (r *Redis) XPending(ctx context.Context, stream, group string, count int64) ([]PendingMessage, error) {
+	pending, err := r.conn.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("pending messages of %s: %w", stream, err)
+	}
+
+	result := make([]PendingMessage, len(pending))
+	for i, p := range pending {
+		result[i] = PendingMessage{
+			ID:         p.ID,
+			Consumer:   p.Consumer,
+			Idle:       p.Idle,
+			Deliveries: p.RetryCount,
+		}
+	}
+
+	return result, nil
+}
+
+func streamMessages(messages []redis.XMessage) []StreamMessage {
+	result := make([]StreamMessage, len(messages))
+	for i, m := range messages {
+		result[i] = StreamMessage{ID: m.ID, Values: m.Values}
+	}
+	return result
+}
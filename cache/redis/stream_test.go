@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedis_Streams(t *testing.T) {
+	redisInitFns := []redisInitFn{redisInit, redisClusterInit}
+	for _, redisInit := range redisInitFns {
+		t.Run("", func(t *testing.T) {
+			r, err := redisInit(t)
+			require.NoError(t, err)
+			ctx := context.Background()
+
+			require.NoError(t, r.XGroupCreate(ctx, "events", "workers", "0"))
+			require.NoError(t, r.XGroupCreate(ctx, "events", "workers", "0"), "existing group must be ignored")
+
+			for _, body := range []string{"a", "b", "c"} {
+				_, err := r.XAdd(ctx, "events", map[string]interface{}{"body": body}, 2)
+				require.NoError(t, err)
+			}
+			length, err := r.XLen(ctx, "events")
+			require.NoError(t, err)
+			assert.LessOrEqual(t, length, int64(3))
+
+			messages, err := r.XReadGroup(ctx, "events", "workers", "first", 10, 10*time.Millisecond)
+			require.NoError(t, err)
+			require.NotEmpty(t, messages)
+			assert.Equal(t, "c", messages[len(messages)-1].Values["body"])
+
+			messages, err = r.XReadGroup(ctx, "events", "workers", "first", 10, 10*time.Millisecond)
+			require.NoError(t, err)
+			assert.Empty(t, messages, "read must time out without new messages")
+
+			pending, err := r.XPending(ctx, "events", "workers", 10)
+			require.NoError(t, err)
+			require.Len(t, pending, int(length))
+			assert.Equal(t, "first", pending[0].Consumer)
+			assert.Equal(t, int64(1), pending[0].Deliveries)
+
+			require.NoError(t, r.XAck(ctx, "events", "workers", pending[0].ID))
+
+			claimed, next, err := r.XAutoClaim(ctx, "events", "workers", "second", 0, "0-0", 10)
+			require.NoError(t, err)
+			assert.Len(t, claimed, int(length)-1)
+			assert.Equal(t, "0-0", next)
+
+			pending, err = r.XPending(ctx, "events", "workers", 10)
+			require.NoError(t, err)
+			for _, p := range pending {
+				assert.Equal(t, "second", p.Consumer)
+			}
+		})
+	}
+}
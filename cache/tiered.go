@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+)
+
+const (
+	defaultLocalSize = 10000
+	defaultLocalTTL  = time.Minute
+)
+
+// TieredStore is implemented by *redis.Redis, values are encoded by its codec.
+type TieredStore interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	SetBytes(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channels []string, handler redis.PubSubHandler, opts ...redis.SubscribeOption) (*redis.Subscription, error)
+}
+
+type TieredOption func(o *tieredOptions)
+
+type tieredOptions struct {
+	localSize int
+	localTTL  time.Duration
+	metric    TieredCacheMetric
+}
+
+// WithLocalSize sets the maximum number of values kept in the process, 10000 by default.
+func WithLocalSize(size int) TieredOption {
+	return func(o *tieredOptions) {
+		o.localSize = size
+	}
+}
+
+// WithLocalTTL sets how long values are kept in the process, 1 minute by default.
+// It bounds the staleness of values whose invalidation is lost.
+func WithLocalTTL(ttl time.Duration) TieredOption {
+	return func(o *tieredOptions) {
+		o.localTTL = ttl
+	}
+}
+
+func WithTieredCacheMetric(metric TieredCacheMetric) TieredOption {
+	return func(o *tieredOptions) {
+		o.metric = metric
+	}
+}
+
+// TieredCache keeps hot values in a bounded in-process LRU in front of Redis,
+// values are stored in Redis encoded by the codec of the store, see redis.Redis.WithCodec.
+//
+// Set and Delete publish the key to the invalidation channel, so all replicas using the same channel
+// evict their local copy. The local tier is purged when the subscription is restored after a connection loss,
+// since invalidations published meanwhile are lost. Values returned from the local tier are shared,
+// callers must not modify them.
+type TieredCache[V any] struct {
+	store   TieredStore
+	local   *lru[V]
+	channel string
+	nodeID  string
+	options *tieredOptions
+
+	// generation is incremented on every invalidation, values read from Redis before an invalidation
+	// may be stale and aren't kept locally
+	generation uint64
+}
+
+// NewTieredCache creates the cache and subscribes to the invalidation channel until the context is done.
+func NewTieredCache[V any](ctx context.Context, store TieredStore, channel string, opts ...TieredOption) (*TieredCache[V], error) {
+	options := &tieredOptions{
+		localSize: defaultLocalSize,
+		localTTL:  defaultLocalTTL,
+		metric:    &NullableTieredCacheMetric{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.localSize <= 0 || options.localTTL <= 0 {
+		return nil, fmt.Errorf("invalid local tier: size %d, ttl %s", options.localSize, options.localTTL)
+	}
+
+	nodeID, err := newNodeID()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TieredCache[V]{
+		store:   store,
+		local:   newLRU[V](options.localSize),
+		channel: channel,
+		nodeID:  nodeID,
+		options: options,
+	}
+
+	_, err = store.Subscribe(ctx, []string{channel}, c.handleInvalidation, redis.WithResubscribeHook(c.purge))
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns the value of the key from the local tier or from Redis. ErrNotFound is returned if there is no value.
+func (c *TieredCache[V]) Get(ctx context.Context, key string) (V, error) {
+	if value, ok := c.local.get(key); ok {
+		c.options.metric.LocalHit()
+		return value, nil
+	}
+
+	generation := atomic.LoadUint64(&c.generation)
+
+	var value V
+	data, err := c.store.GetBytes(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		c.options.metric.Miss()
+		return value, ErrNotFound
+	} else if err != nil {
+		return value, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	if err := c.store.Decode(data, &value); err != nil {
+		return value, fmt.Errorf("decode %s: %w", key, err)
+	}
+	c.options.metric.RedisHit()
+
+	if atomic.LoadUint64(&c.generation) == generation {
+		c.local.add(key, value, c.options.localTTL)
+	}
+
+	return value, nil
+}
+
+// Set stores the value in both tiers and invalidates the local copies of other replicas.
+func (c *TieredCache[V]) Set(ctx context.Context, key string, value V, ttl time.Duration) error {
+	data, err := c.store.Encode(value)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+
+	// Gets reading the previous value from Redis meanwhile mustn't keep it locally
+	atomic.AddUint64(&c.generation, 1)
+	if err := c.store.SetBytes(ctx, key, data, ttl); err != nil {
+		return fmt.Errorf("set %s: %w", key, err)
+	}
+
+	localTTL := c.options.localTTL
+	if ttl > 0 && ttl < localTTL {
+		localTTL = ttl
+	}
+	c.local.add(key, value, localTTL)
+
+	return c.invalidate(ctx, key)
+}
+
+// Delete removes the value from both tiers and invalidates the local copies of other replicas.
+func (c *TieredCache[V]) Delete(ctx context.Context, key string) error {
+	atomic.AddUint64(&c.generation, 1)
+	err := c.store.Delete(ctx, key)
+	c.local.remove(key)
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+
+	return c.invalidate(ctx, key)
+}
+
+// invalidate publishes the key prefixed with the node ID, so the node skips its own invalidations.
+// The generation is bumped again after the local update, since Gets may have read Redis meanwhile.
+func (c *TieredCache[V]) invalidate(ctx context.Context, key string) error {
+	atomic.AddUint64(&c.generation, 1)
+	if err := c.store.Publish(ctx, c.channel, []byte(c.nodeID+":"+key)); err != nil {
+		return fmt.Errorf("invalidate %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (c *TieredCache[V]) handleInvalidation(_ context.Context, msg redis.PubSubMessage) error {
+	nodeID, key, ok := strings.Cut(string(msg.Payload), ":")
+	if !ok {
+		return fmt.Errorf("invalid cache invalidation on %s: %q", c.channel, msg.Payload)
+	}
+	if nodeID == c.nodeID {
+		return nil
+	}
+
+	atomic.AddUint64(&c.generation, 1)
+	c.local.remove(key)
+	c.options.metric.Invalidated()
+
+	return nil
+}
+
+func (c *TieredCache[V]) purge() {
+	atomic.AddUint64(&c.generation, 1)
+	c.local.purge()
+}
+
+func newNodeID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate node id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+)
+
+type recordingTieredCacheMetric struct {
+	mu                                        sync.Mutex
+	localHits, redisHits, misses, invalidated int
+}
+
+func (m *recordingTieredCacheMetric) LocalHit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.localHits++
+}
+
+func (m *recordingTieredCacheMetric) RedisHit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redisHits++
+}
+
+func (m *recordingTieredCacheMetric) Miss() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses++
+}
+
+func (m *recordingTieredCacheMetric) Invalidated() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invalidated++
+}
+
+func (m *recordingTieredCacheMetric) invalidations() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.invalidated
+}
+
+func newTestTieredCaches(t *testing.T, metrics ...TieredCacheMetric) ([]*TieredCache[token], *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var caches []*TieredCache[token]
+	for _, metric := range metrics {
+		store, err := redis.Init(ctx, fmt.Sprintf("redis://%s", mr.Addr()))
+		require.NoError(t, err)
+
+		c, err := NewTieredCache[token](ctx, store, "tokens:invalidations", WithTieredCacheMetric(metric))
+		require.NoError(t, err)
+		caches = append(caches, c)
+	}
+
+	return caches, mr
+}
+
+func TestTieredCache_Get(t *testing.T) {
+	metric := &recordingTieredCacheMetric{}
+	caches, mr := newTestTieredCaches(t, metric)
+	c := caches[0]
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, "btc")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	require.NoError(t, mr.Set("btc", `{"symbol":"BTC","decimals":8}`))
+	for i := 0; i < 3; i++ {
+		value, err := c.Get(ctx, "btc")
+		require.NoError(t, err)
+		assert.Equal(t, tokens["btc"], value)
+	}
+
+	assert.Equal(t, 1, metric.misses)
+	assert.Equal(t, 1, metric.redisHits)
+	assert.Equal(t, 2, metric.localHits)
+}
+
+func TestTieredCache_WithCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store, mr := newTestStore(t)
+	codecStore, err := store.WithCodec(redis.MsgpackCodec{})
+	require.NoError(t, err)
+
+	c, err := NewTieredCache[token](ctx, codecStore, "tokens:invalidations")
+	require.NoError(t, err)
+	require.NoError(t, c.Set(ctx, "eth", tokens["eth"], time.Hour))
+
+	data, err := mr.Get("eth")
+	require.NoError(t, err)
+	assert.False(t, json.Valid([]byte(data)), "values must be encoded by the codec")
+
+	var stored token
+	require.NoError(t, store.Get(ctx, "eth", &stored))
+	assert.Equal(t, tokens["eth"], stored)
+}
+
+func TestTieredCache_Invalidation(t *testing.T) {
+	first, second := &recordingTieredCacheMetric{}, &recordingTieredCacheMetric{}
+	caches, mr := newTestTieredCaches(t, first, second)
+	ctx := context.Background()
+
+	require.NoError(t, caches[0].Set(ctx, "eth", tokens["eth"], time.Hour))
+	assert.Equal(t, time.Hour, mr.TTL("eth"))
+	require.Eventually(t, func() bool {
+		return second.invalidations() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	value, err := caches[1].Get(ctx, "eth")
+	require.NoError(t, err)
+	assert.Equal(t, tokens["eth"], value)
+
+	updated := token{Symbol: "ETH", Decimals: 9}
+	require.NoError(t, caches[0].Set(ctx, "eth", updated, time.Hour))
+	require.Eventually(t, func() bool {
+		return second.invalidations() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	value, err = caches[1].Get(ctx, "eth")
+	require.NoError(t, err)
+	assert.Equal(t, updated, value)
+	assert.Equal(t, 0, first.invalidations(), "own invalidations must be skipped")
+
+	value, err = caches[0].Get(ctx, "eth")
+	require.NoError(t, err)
+	assert.Equal(t, updated, value)
+	assert.Equal(t, 1, first.localHits)
+
+	require.NoError(t, caches[1].Delete(ctx, "eth"))
+	require.Eventually(t, func() bool {
+		return first.invalidations() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = caches[0].Get(ctx, "eth")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestNewTieredCache_InvalidOptions(t *testing.T) {
+	_, err := NewTieredCache[token](context.Background(), nil, "invalidations", WithLocalSize(0))
+	assert.Error(t, err)
+}
+
+func TestNewTieredCacheMetric(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metric := NewTieredCacheMetric("test", prometheus.Labels{"cache": "tokens"}, reg)
+
+	metric.LocalHit()
+	metric.Invalidated()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	assert.True(t, names["test_cache_tier_requests_total"])
+	assert.True(t, names["test_cache_invalidations_total"])
+}
+
+// pausingStore pauses GetBytes after reading the value and Delete after deleting it
+type pausingStore struct {
+	*redis.Redis
+	read, resumeGet       chan struct{}
+	deleted, resumeDelete chan struct{}
+}
+
+func (s *pausingStore) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.Redis.GetBytes(ctx, key)
+	s.read <- struct{}{}
+	<-s.resumeGet
+	return data, err
+}
+
+func (s *pausingStore) Delete(ctx context.Context, key string) error {
+	err := s.Redis.Delete(ctx, key)
+	s.deleted <- struct{}{}
+	<-s.resumeDelete
+	return err
+}
+
+func TestTieredCache_DeleteDuringGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store, _ := newTestStore(t)
+	require.NoError(t, store.Set(ctx, "btc", tokens["btc"], time.Hour))
+
+	paused := &pausingStore{
+		Redis:        store,
+		read:         make(chan struct{}),
+		resumeGet:    make(chan struct{}),
+		deleted:      make(chan struct{}),
+		resumeDelete: make(chan struct{}),
+	}
+	c, err := NewTieredCache[token](ctx, paused, "tokens:invalidations")
+	require.NoError(t, err)
+
+	got := make(chan struct{})
+	go func() {
+		defer close(got)
+		_, err := c.Get(ctx, "btc")
+		assert.NoError(t, err)
+	}()
+	<-paused.read
+
+	// the Get reading the deleted value finishes while the value is being deleted
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		assert.NoError(t, c.Delete(ctx, "btc"))
+	}()
+	<-paused.deleted
+	close(paused.resumeGet)
+	<-got
+	close(paused.resumeDelete)
+	<-deleted
+
+	_, ok := c.local.get("btc")
+	assert.False(t, ok, "the value read before Delete must not be kept locally")
+}
@@ -0,0 +1,167 @@
+// Command mqreplay lists and replays messages of a RabbitMQ dead letter queue.
+//
+// List the messages of a dead letter queue having the coin header "btc":
+//
+//	mqreplay -url amqp://localhost:5672 -queue deposits.dlq -header coin=btc
+//
+// Replay the messages with "eth" at the path "transfer.coin" of the JSON body back to their queue:
+//
+//	mqreplay -url amqp://localhost:5672 -queue deposits.dlq -json transfer.coin=eth -replay
+//
+// The URL can be passed by the MQ_URL environment variable as well.
+// Add -dry-run to list the messages which would be replayed without replaying them.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/trustwallet/go-libs/mq"
+)
+
+// pairs collects repeated key=value flags
+type pairs [][2]string
+
+func (p *pairs) String() string {
+	return fmt.Sprint(*p)
+}
+
+func (p *pairs) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	*p = append(*p, [2]string{key, val})
+	return nil
+}
+
+type output struct {
+	MessageID     string                 `json:"message_id,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Exchange      mq.ExchangeName        `json:"exchange"`
+	RoutingKey    mq.ExchangeKey         `json:"routing_key"`
+	Timestamp     string                 `json:"timestamp,omitempty"`
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	Deaths        []mq.Death             `json:"deaths,omitempty"`
+	Body          string                 `json:"body"`
+}
+
+// options are the parsed command line flags
+type options struct {
+	url        string
+	queue      string
+	limit      int
+	replay     bool
+	toExchange bool
+	dryRun     bool
+	filters    []mq.DeadLetterFilter
+}
+
+func main() {
+	var (
+		url        = flag.String("url", os.Getenv("MQ_URL"), "RabbitMQ URL")
+		queue      = flag.String("queue", "", "dead letter queue")
+		limit      = flag.Int("limit", 100, "maximum number of messages fetched from the queue")
+		replay     = flag.Bool("replay", false, "replay the matching messages")
+		toExchange = flag.Bool("to-exchange", false,
+			"replay to the original exchange and routing key instead of the original queue")
+		dryRun      = flag.Bool("dry-run", false, "list the messages which would be replayed")
+		headers     pairs
+		jsonFilters pairs
+	)
+	flag.Var(&headers, "header", "filter by header value, name=value, repeatable")
+	flag.Var(&jsonFilters, "json", "filter by value at the dot separated path of the JSON body, path=value, repeatable")
+	flag.Parse()
+
+	if *url == "" || *queue == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := options{
+		url:        *url,
+		queue:      *queue,
+		limit:      *limit,
+		replay:     *replay,
+		toExchange: *toExchange,
+		dryRun:     *dryRun,
+	}
+	for _, h := range headers {
+		opts.filters = append(opts.filters, mq.HeaderFilter(h[0], h[1]))
+	}
+	for _, j := range jsonFilters {
+		opts.filters = append(opts.filters, mq.JSONPathFilter(j[0], j[1]))
+	}
+
+	// run returns instead of exiting, so the connection is closed properly
+	if err := run(opts); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+func run(opts options) error {
+	client, err := mq.Connect(opts.url)
+	if err != nil {
+		return fmt.Errorf("connect to MQ: %w", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	dlq := client.InitDeadLetterQueue(mq.QueueName(opts.queue))
+
+	var messages []mq.DeadLetter
+	if opts.replay || opts.dryRun {
+		target := mq.ReplayToQueue
+		if opts.toExchange {
+			target = mq.ReplayToExchange
+		}
+
+		messages, err = dlq.Replay(ctx, mq.ReplayOptions{
+			Limit:  opts.limit,
+			Filter: mq.AllFilters(opts.filters...),
+			Target: target,
+			DryRun: opts.dryRun,
+		})
+	} else {
+		messages, err = dlq.Peek(ctx, opts.limit, mq.AllFilters(opts.filters...))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, msg := range messages {
+		out := output{
+			MessageID:     msg.MessageID,
+			CorrelationID: msg.CorrelationID,
+			Exchange:      msg.Exchange,
+			RoutingKey:    msg.RoutingKey,
+			Headers:       msg.Headers,
+			Deaths:        msg.Deaths,
+			Body:          string(msg.Body),
+		}
+		if !msg.Timestamp.IsZero() {
+			out.Timestamp = msg.Timestamp.String()
+		}
+		if encodeErr := encoder.Encode(out); encodeErr != nil {
+			log.Errorf("Encode message %s: %v", msg.MessageID, encodeErr)
+		}
+	}
+
+	switch {
+	case err != nil:
+		return fmt.Errorf("process queue %s: %w", opts.queue, err)
+	case opts.dryRun:
+		log.Infof("%d messages would be replayed", len(messages))
+	case opts.replay:
+		log.Infof("Replayed %d messages", len(messages))
+	default:
+		log.Infof("Found %d messages", len(messages))
+	}
+
+	return nil
+}
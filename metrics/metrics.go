@@ -11,6 +11,7 @@ const (
 	executionDurationSecondsKey = "execution_duration_seconds"
 	executionSucceededTotalKey  = "execution_succeeded_total"
 	executionFailedTotalKey     = "execution_failed_total"
+	executionTimedOutTotalKey   = "execution_timed_out_total"
 )
 
 type Collectors map[string]prometheus.Collector
@@ -22,11 +23,18 @@ type PerformanceMetric interface {
 	Failure(labelValues ...string)
 }
 
+// TimeoutMetric is implemented by performance metrics which count timed out executions.
+// A timed out execution is still reported as a Failure.
+type TimeoutMetric interface {
+	Timeout(labelValues ...string)
+}
+
 type performanceMetric struct {
 	executionStarted         *prometheus.GaugeVec
 	executionDurationSeconds *prometheus.HistogramVec
 	executionSucceededTotal  *prometheus.CounterVec
 	executionFailedTotal     *prometheus.CounterVec
+	executionTimedOutTotal   *prometheus.CounterVec
 }
 
 func NewPerformanceMetric(
@@ -59,13 +67,21 @@ func NewPerformanceMetric(
 		Help:      "Total number of the executions which failed.",
 	}, labelNames)
 
-	Register(staticLabels, reg, executionStarted, executionDurationSeconds, executionSucceededTotal, executionFailedTotal)
+	executionTimedOutTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      executionTimedOutTotalKey,
+		Help:      "Total number of the executions which timed out.",
+	}, labelNames)
+
+	Register(staticLabels, reg, executionStarted, executionDurationSeconds, executionSucceededTotal, executionFailedTotal,
+		executionTimedOutTotal)
 
 	return &performanceMetric{
 		executionStarted:         executionStarted,
 		executionDurationSeconds: executionDurationSeconds,
 		executionSucceededTotal:  executionSucceededTotal,
 		executionFailedTotal:     executionFailedTotal,
+		executionTimedOutTotal:   executionTimedOutTotal,
 	}
 }
 
@@ -83,11 +99,17 @@ func (m *performanceMetric) Duration(start time.Time, labelValues ...string) {
 func (m *performanceMetric) Success(labelValues ...string) {
 	m.executionSucceededTotal.WithLabelValues(labelValues...).Inc()
 	m.executionFailedTotal.WithLabelValues(labelValues...).Add(0)
+	m.executionTimedOutTotal.WithLabelValues(labelValues...).Add(0)
 }
 
 func (m *performanceMetric) Failure(labelValues ...string) {
 	m.executionFailedTotal.WithLabelValues(labelValues...).Inc()
 	m.executionSucceededTotal.WithLabelValues(labelValues...).Add(0)
+	m.executionTimedOutTotal.WithLabelValues(labelValues...).Add(0)
+}
+
+func (m *performanceMetric) Timeout(labelValues ...string) {
+	m.executionTimedOutTotal.WithLabelValues(labelValues...).Inc()
 }
 
 type NullablePerformanceMetric struct{}
@@ -99,3 +121,4 @@ func (NullablePerformanceMetric) Start(_ ...string) time.Time {
 func (NullablePerformanceMetric) Duration(_ time.Time, _ ...string) {}
 func (NullablePerformanceMetric) Success(_ ...string)               {}
 func (NullablePerformanceMetric) Failure(_ ...string)               {}
+func (NullablePerformanceMetric) Timeout(_ ...string)               {}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+)
+
+// RateLimiter is implemented by redis.RateLimiter.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (redis.RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key to limit the request by, false means the request is not limited.
+type RateLimitKeyFunc func(c *gin.Context) (string, bool)
+
+// RateLimitByIP limits requests by the client IP.
+func RateLimitByIP() RateLimitKeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		return "ip:" + c.ClientIP(), true
+	}
+}
+
+// RateLimitByAPIKey limits requests by the API key from the header, requests without the key are limited by the client IP.
+func RateLimitByAPIKey(header string) RateLimitKeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		if key := c.GetHeader(header); key != "" {
+			return "api_key:" + key, true
+		}
+		return "ip:" + c.ClientIP(), true
+	}
+}
+
+// RateLimit responds with 429 to requests over the limit.
+// Every response gets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// rejected ones get Retry-After. Requests are allowed if the limiter fails.
+func RateLimit(limiter RateLimiter, keyFunc RateLimitKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := keyFunc(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		res, err := limiter.Allow(c.Request.Context(), key)
+		if err != nil {
+			log.WithError(err).WithField("key", key).Error("Rate limit failed")
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+
+		if !res.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			_ = c.AbortWithError(http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded for %s", key))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ceilSeconds rounds the duration up, so clients don't retry before the limit is available
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
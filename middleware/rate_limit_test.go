@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+)
+
+type failingRateLimiter struct{}
+
+func (failingRateLimiter) Allow(context.Context, string) (redis.RateLimitResult, error) {
+	return redis.RateLimitResult{}, errors.New("redis is down")
+}
+
+func newRateLimitRouter(limiter RateLimiter, keyFunc RateLimitKeyFunc) *gin.Engine {
+	router := gin.New()
+	router.GET("/ping", RateLimit(limiter, keyFunc), func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+	return router
+}
+
+func newTestRedisRateLimiter(t *testing.T, limit redis.RateLimit) *redis.RateLimiter {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	r, err := redis.Init(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	require.NoError(t, err)
+
+	limiter, err := redis.NewRateLimiter(r, redis.SlidingWindow, limit, "rate_limit:")
+	require.NoError(t, err)
+
+	return limiter
+}
+
+func TestRateLimit(t *testing.T) {
+	router := newRateLimitRouter(newTestRedisRateLimiter(t, redis.PerMinute(2)), RateLimitByIP())
+
+	w := performRequest("GET", "/ping", router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	w = performRequest("GET", "/ping", router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = performRequest("GET", "/ping", router)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestRateLimit_ByAPIKey(t *testing.T) {
+	router := newRateLimitRouter(newTestRedisRateLimiter(t, redis.PerMinute(1)), RateLimitByAPIKey("X-API-Key"))
+
+	request := func(apiKey string) int {
+		r := httptest.NewRequest("GET", "/ping", nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("first"))
+	assert.Equal(t, http.StatusTooManyRequests, request("first"))
+	assert.Equal(t, http.StatusOK, request("second"))
+	assert.Equal(t, http.StatusOK, request(""), "requests without the key must be limited by IP")
+	assert.Equal(t, http.StatusTooManyRequests, request(""))
+}
+
+func TestRateLimit_SkipAndFailOpen(t *testing.T) {
+	router := newRateLimitRouter(failingRateLimiter{}, RateLimitByIP())
+	w := performRequest("GET", "/ping", router)
+	assert.Equal(t, http.StatusOK, w.Code, "requests must be allowed if the limiter fails")
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	router = newRateLimitRouter(newTestRedisRateLimiter(t, redis.PerMinute(1)), func(*gin.Context) (string, bool) {
+		return "", false
+	})
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, performRequest("GET", "/ping", router).Code)
+	}
+}
+
+func TestCeilSeconds(t *testing.T) {
+	assert.Equal(t, 0, ceilSeconds(0))
+	assert.Equal(t, 1, ceilSeconds(time.Millisecond))
+	assert.Equal(t, 2, ceilSeconds(1500*time.Millisecond))
+}
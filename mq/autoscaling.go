@@ -0,0 +1,207 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// AutoscalingOptions configures the number of consumer workers to follow the backlog of the queue.
+//
+// Every interval the consumer inspects the number of ready messages in the queue and estimates
+// the number of workers needed to process them within TargetDrainTime by the average processing latency.
+// The consumer grows to the estimate at once and shrinks by one worker per interval to avoid flapping.
+type AutoscalingOptions struct {
+	MinWorkers int
+	MaxWorkers int
+	// Interval between inspections of the queue.
+	Interval time.Duration
+	// TargetDrainTime is the time the backlog of the queue is expected to be processed in.
+	TargetDrainTime time.Duration
+	// PrefetchPerWorker is the number of unacknowledged messages per running worker,
+	// the prefetch count of the consumer is adjusted on every change of the number of workers.
+	PrefetchPerWorker int
+}
+
+func DefaultAutoscalingOptions(minWorkers, maxWorkers int) *AutoscalingOptions {
+	return &AutoscalingOptions{
+		MinWorkers:        minWorkers,
+		MaxWorkers:        maxWorkers,
+		Interval:          10 * time.Second,
+		TargetDrainTime:   30 * time.Second,
+		PrefetchPerWorker: 2,
+	}
+}
+
+func (o *AutoscalingOptions) validate() error {
+	switch {
+	case o.MinWorkers < 1 || o.MaxWorkers < o.MinWorkers:
+		return fmt.Errorf("invalid autoscaling workers: min %d, max %d", o.MinWorkers, o.MaxWorkers)
+	case o.Interval <= 0:
+		return fmt.Errorf("invalid autoscaling interval: %s", o.Interval)
+	case o.TargetDrainTime <= 0:
+		return fmt.Errorf("invalid autoscaling target drain time: %s", o.TargetDrainTime)
+	case o.PrefetchPerWorker < 1:
+		return fmt.Errorf("invalid autoscaling prefetch per worker: %d", o.PrefetchPerWorker)
+	}
+
+	return nil
+}
+
+// desiredWorkers returns the number of workers for the next interval
+func (o *AutoscalingOptions) desiredWorkers(current, backlog int, latency time.Duration) int {
+	desired := current
+	if latency > 0 {
+		desired = int(math.Ceil(float64(backlog) * float64(latency) / float64(o.TargetDrainTime)))
+	}
+
+	if desired < current {
+		desired = current - 1
+	}
+
+	switch {
+	case desired < o.MinWorkers:
+		return o.MinWorkers
+	case desired > o.MaxWorkers:
+		return o.MaxWorkers
+	default:
+		return desired
+	}
+}
+
+// latencyWindow collects the average processing latency between inspections
+type latencyWindow struct {
+	mu    sync.Mutex
+	sum   time.Duration
+	count int
+	last  time.Duration
+}
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.sum += d
+	w.count++
+}
+
+// average returns the average latency since the previous call,
+// or the previous average if nothing was processed meanwhile
+func (w *latencyWindow) average() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.count > 0 {
+		w.last = w.sum / time.Duration(w.count)
+		w.sum, w.count = 0, 0
+	}
+
+	return w.last
+}
+
+type autoscaler struct {
+	consumer    *consumer
+	options     *AutoscalingOptions
+	setPrefetch qosFunc
+
+	messages <-chan amqp.Delivery
+	stop     chan struct{}
+
+	// workers holds the stop channel of every running worker
+	workers []chan struct{}
+}
+
+func (c *consumer) startAutoscaling(ctx context.Context) error {
+	options := c.options.Autoscaling
+	if err := options.validate(); err != nil {
+		return err
+	}
+	if c.options.PartitionKey != nil {
+		return errors.New("autoscaling is not supported in the ordering mode")
+	}
+
+	stop := make(chan struct{})
+	c.stopChan = stop
+	if c.latency == nil {
+		// kept on reconnect, since the workers of the previous connection might still observe it
+		c.latency = &latencyWindow{}
+	}
+
+	messages, setPrefetch, err := c.broker.subscribe(ctx, stop, c.queue.Name(), options.MinWorkers*options.PrefetchPerWorker)
+	if err != nil {
+		return fmt.Errorf("get message channel: %v", err)
+	}
+
+	s := &autoscaler{
+		consumer:    c,
+		options:     options,
+		setPrefetch: setPrefetch,
+		messages:    messages,
+		stop:        stop,
+	}
+	s.scale(ctx, options.MinWorkers)
+	go s.run(ctx, stop)
+
+	log.Infof("Started %d-%d autoscaled MQ consumer workers for queue %s",
+		options.MinWorkers, options.MaxWorkers, c.queue.Name())
+
+	return nil
+}
+
+func (s *autoscaler) run(ctx context.Context, stopChan chan struct{}) {
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			state, err := s.consumer.broker.InspectQueue(s.consumer.queue.Name())
+			if err != nil {
+				log.Errorf("Inspect queue %s for autoscaling: %v", s.consumer.queue.Name(), err)
+				continue
+			}
+
+			latency := s.consumer.latency.average()
+			s.scale(ctx, s.options.desiredWorkers(len(s.workers), state.Messages, latency))
+		}
+	}
+}
+
+// scale starts or stops workers up to the given number.
+// Stopped workers finish the message in progress, its prefetched messages are delivered to the remaining workers.
+func (s *autoscaler) scale(ctx context.Context, workers int) {
+	current := len(s.workers)
+	if workers == current {
+		return
+	}
+
+	for len(s.workers) < workers {
+		stop := make(chan struct{})
+		s.workers = append(s.workers, stop)
+		go s.consumer.consume(ctx, s.messages, s.stop, stop)
+	}
+	for len(s.workers) > workers {
+		last := len(s.workers) - 1
+		close(s.workers[last])
+		s.workers = s.workers[:last]
+	}
+
+	if err := s.setPrefetch(workers * s.options.PrefetchPerWorker); err != nil {
+		log.Errorf("Set prefetch of consumer for queue %s: %v", s.consumer.queue.Name(), err)
+	}
+
+	if current > 0 {
+		log.Infof("Scaled MQ consumer workers for queue %s from %d to %d", s.consumer.queue.Name(), current, workers)
+	}
+	s.consumer.broker.clientMetric().ConsumerWorkers(s.consumer.queue.Name(), workers)
+}
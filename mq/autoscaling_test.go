@@ -0,0 +1,116 @@
+package mq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type workersClientMetric struct {
+	NullableClientMetric
+
+	mu      sync.Mutex
+	workers []int
+}
+
+func (m *workersClientMetric) ConsumerWorkers(_ QueueName, workers int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers = append(m.workers, workers)
+}
+
+func (m *workersClientMetric) history() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int{}, m.workers...)
+}
+
+func TestAutoscalingOptions_DesiredWorkers(t *testing.T) {
+	options := DefaultAutoscalingOptions(1, 10)
+	options.TargetDrainTime = 10 * time.Second
+
+	// 100 messages by 0.5s need 5 workers to be processed in 10s
+	assert.Equal(t, 5, options.desiredWorkers(1, 100, 500*time.Millisecond))
+	assert.Equal(t, 10, options.desiredWorkers(1, 1000, 500*time.Millisecond))
+	// shrinks by one worker at a time
+	assert.Equal(t, 4, options.desiredWorkers(5, 0, 500*time.Millisecond))
+	assert.Equal(t, 1, options.desiredWorkers(1, 0, 500*time.Millisecond))
+	// unknown latency keeps the current number
+	assert.Equal(t, 3, options.desiredWorkers(3, 100, 0))
+}
+
+func TestAutoscalingOptions_Validate(t *testing.T) {
+	assert.NoError(t, DefaultAutoscalingOptions(1, 1).validate())
+	assert.Error(t, DefaultAutoscalingOptions(0, 1).validate())
+	assert.Error(t, DefaultAutoscalingOptions(2, 1).validate())
+
+	options := DefaultAutoscalingOptions(1, 2)
+	options.PrefetchPerWorker = 0
+	assert.Error(t, options.validate())
+}
+
+func TestLatencyWindow(t *testing.T) {
+	var w latencyWindow
+	assert.Zero(t, w.average())
+
+	w.observe(time.Second)
+	w.observe(3 * time.Second)
+	assert.Equal(t, 2*time.Second, w.average())
+	// keeps the previous average without observations
+	assert.Equal(t, 2*time.Second, w.average())
+}
+
+func TestConsumer_Autoscaling(t *testing.T) {
+	broker := NewMemoryBroker()
+	metric := &workersClientMetric{}
+	broker.metric = metric
+	require.NoError(t, broker.InitQueue("deposits").Declare())
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, broker.InitQueue("deposits").Publish([]byte("deposit")))
+	}
+
+	options := DefaultConsumerOptions(1)
+	options.Autoscaling = &AutoscalingOptions{
+		MinWorkers:        1,
+		MaxWorkers:        4,
+		Interval:          20 * time.Millisecond,
+		TargetDrainTime:   50 * time.Millisecond,
+		PrefetchPerWorker: 1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := broker.InitConsumer("deposits", options, MessageProcessorFunc(func(Message) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}))
+	require.NoError(t, consumer.Start(ctx))
+	require.NoError(t, broker.WaitForAck(ctx, "deposits", 50))
+
+	require.Eventually(t, func() bool {
+		history := metric.history()
+		return history[len(history)-1] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	history := metric.history()
+	assert.Equal(t, 1, history[0])
+	assert.Contains(t, history, 4)
+}
+
+func TestConsumer_AutoscalingWithPartitionKey(t *testing.T) {
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.InitQueue("deposits").Declare())
+
+	options := DefaultConsumerOptions(1)
+	options.Autoscaling = DefaultAutoscalingOptions(1, 2)
+	options.PartitionKey = PartitionByRoutingKey
+
+	consumer := broker.InitConsumer("deposits", options, MessageProcessorFunc(func(Message) error { return nil }))
+	assert.Error(t, consumer.Start(context.Background()))
+}
@@ -0,0 +1,160 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+// BatchProcessor processes a batch of messages, the context is cancelled once the consumer is shut down
+// or ConsumerOptions.ProcessingTimeout is exceeded.
+type BatchProcessor interface {
+	Process(ctx context.Context, messages []Message) error
+}
+
+// BatchProcessorFunc is an adapter to allow to use
+// an ordinary functions as mq BatchProcessor.
+type BatchProcessorFunc func(ctx context.Context, messages []Message) error
+
+func (f BatchProcessorFunc) Process(ctx context.Context, m []Message) error {
+	return f(ctx, m)
+}
+
+// batchConsumer accumulates deliveries and processes them at once.
+// Every worker uses a dedicated amqp channel, so that a batch can be acknowledged with a single multiple ack
+// without acknowledging deliveries of the other workers.
+type batchConsumer struct {
+	*consumer
+
+	processor BatchProcessor
+	options   *BatchConsumerOptions
+}
+
+func (c *Client) InitBatchConsumer(queueName QueueName, options *BatchConsumerOptions, processor BatchProcessor) Consumer {
+	return newBatchConsumer(c, c.InitQueue(queueName), options, processor)
+}
+
+func newBatchConsumer(broker broker, queue Queue, options *BatchConsumerOptions, processor BatchProcessor) *batchConsumer {
+	return &batchConsumer{
+		consumer:  newConsumer(broker, queue, &options.ConsumerOptions, nil),
+		processor: processor,
+		options:   options,
+	}
+}
+
+func (c *batchConsumer) Start(ctx context.Context) error {
+	if err := c.options.validate(); err != nil {
+		return fmt.Errorf("invalid batch consumer options: %w", err)
+	}
+
+	stop := make(chan struct{})
+	c.stopChan = stop
+
+	for w := 1; w <= c.options.Workers; w++ {
+		// prefetch is adjusted to the batch size, so that a batch can always be filled up
+		messages, err := c.messageChannel(ctx, stop, c.options.BatchSize)
+		if err != nil {
+			return fmt.Errorf("get message channel: %v", err)
+		}
+
+		go c.consume(ctx, messages, stop)
+	}
+
+	log.Infof("Started %d MQ batch consumer workers for queue %s", c.options.Workers, c.queue.Name())
+
+	return nil
+}
+
+func (c *batchConsumer) Reconnect(ctx context.Context) error {
+	if c.stopChan != nil {
+		close(c.stopChan)
+	}
+
+	return c.Start(ctx)
+}
+
+func (c *batchConsumer) consume(ctx context.Context, messages <-chan amqp.Delivery, stop <-chan struct{}) {
+	queueName := string(c.queue.Name())
+
+	batch := make([]amqp.Delivery, 0, c.options.BatchSize)
+	var batchTimeout <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			// not acknowledged deliveries are redelivered by the broker once the channel is closed
+			log.Infof("Finished consuming queue %s", queueName)
+			return
+		case <-stop:
+			log.Infof("Force stopped consuming queue %s", queueName)
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				log.Infof("Delivery channel of queue %s closed", queueName)
+				return
+			}
+
+			c.broker.clientMetric().Delivered(c.queue.Name(), msg.Redelivered)
+
+			if len(batch) == 0 {
+				batchTimeout = time.After(c.options.BatchTimeout)
+			}
+
+			batch = append(batch, msg)
+			if len(batch) < c.options.BatchSize {
+				continue
+			}
+		case <-batchTimeout:
+		}
+
+		c.processBatch(ctx, stop, batch)
+
+		batch = batch[:0]
+		batchTimeout = nil
+	}
+}
+
+func (c *batchConsumer) processBatch(ctx context.Context, stop <-chan struct{}, batch []amqp.Delivery) {
+	messages := make([]Message, len(batch))
+	for i, msg := range batch {
+		messages[i] = msg.Body
+	}
+
+	metric := c.options.PerformanceMetric
+	if metric == nil {
+		metric = &metrics.NullablePerformanceMetric{}
+	}
+
+	start := metric.Start()
+	err := processWithTimeout(ctx, c.options.ProcessingTimeout, func(ctx context.Context) error {
+		return c.processor.Process(ctx, messages)
+	})
+	metric.Duration(start)
+
+	if err == nil {
+		metric.Success()
+		if err := batch[len(batch)-1].Ack(true); err != nil {
+			log.Error(err)
+		}
+		c.broker.clientMetric().Acked(c.queue.Name(), len(batch))
+		return
+	}
+
+	metric.Failure()
+	countTimeout(metric, err)
+	log.Error(err)
+
+	outcome, delay := c.decide(err)
+	if !sleep(ctx, stop, delay) {
+		return
+	}
+
+	for _, msg := range batch {
+		c.settle(msg, outcome)
+	}
+}
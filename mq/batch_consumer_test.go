@@ -0,0 +1,174 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+type ackCall struct {
+	tag      uint64
+	multiple bool
+	requeue  bool
+	ack      bool
+}
+
+type fakeAcknowledger struct {
+	mu    sync.Mutex
+	calls []ackCall
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, ackCall{tag: tag, multiple: multiple, ack: true})
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, ackCall{tag: tag, multiple: multiple, requeue: requeue})
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *fakeAcknowledger) getCalls() []ackCall {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ackCall(nil), a.calls...)
+}
+
+type fakeQueue struct {
+	Queue
+	published []PublishConfig
+}
+
+func (q *fakeQueue) Name() QueueName {
+	return "test"
+}
+
+func (q *fakeQueue) PublishWithConfig(_ []byte, cfg PublishConfig) error {
+	q.published = append(q.published, cfg)
+	return nil
+}
+
+func newTestBatchConsumer(queue Queue, options *BatchConsumerOptions, processor BatchProcessor) *batchConsumer {
+	return &batchConsumer{
+		consumer: &consumer{
+			broker:  NewMemoryBroker(),
+			queue:   queue,
+			options: &options.ConsumerOptions,
+		},
+		processor: processor,
+		options:   options,
+	}
+}
+
+func TestBatchConsumer_AcksFullBatch(t *testing.T) {
+	var batches [][]Message
+	options := DefaultBatchConsumerOptions(1, 3, time.Hour)
+	c := newTestBatchConsumer(&fakeQueue{}, options, BatchProcessorFunc(func(_ context.Context, messages []Message) error {
+		batches = append(batches, messages)
+		return nil
+	}))
+
+	acknowledger := &fakeAcknowledger{}
+	messages := make(chan amqp.Delivery, 3)
+	for tag := uint64(1); tag <= 3; tag++ {
+		messages <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: tag, Body: []byte{byte(tag)}}
+	}
+	close(messages)
+
+	c.consume(context.Background(), messages, nil)
+
+	assert.Equal(t, [][]Message{{{1}, {2}, {3}}}, batches)
+	assert.Equal(t, []ackCall{{tag: 3, multiple: true, ack: true}}, acknowledger.getCalls())
+}
+
+func TestBatchConsumer_ProcessesPartialBatchOnTimeout(t *testing.T) {
+	processed := make(chan []Message, 1)
+	options := DefaultBatchConsumerOptions(1, 10, 50*time.Millisecond)
+	c := newTestBatchConsumer(&fakeQueue{}, options, BatchProcessorFunc(func(_ context.Context, messages []Message) error {
+		processed <- messages
+		return nil
+	}))
+
+	acknowledger := &fakeAcknowledger{}
+	messages := make(chan amqp.Delivery, 2)
+	messages <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, Body: []byte("a")}
+	messages <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 2, Body: []byte("b")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.consume(ctx, messages, nil)
+
+	select {
+	case batch := <-processed:
+		assert.Equal(t, []Message{Message("a"), Message("b")}, batch)
+	case <-time.After(time.Second):
+		t.Fatal("batch was not processed on timeout")
+	}
+}
+
+func TestBatchConsumer_RetriesEachMessageOnFailure(t *testing.T) {
+	options := DefaultBatchConsumerOptions(1, 2, time.Hour)
+	options.RetryDelay = 0
+	options.MaxRetries = 1
+
+	queue := &fakeQueue{}
+	c := newTestBatchConsumer(queue, options, BatchProcessorFunc(func(_ context.Context, messages []Message) error {
+		return errors.New("db is down")
+	}))
+
+	acknowledger := &fakeAcknowledger{}
+	messages := make(chan amqp.Delivery, 2)
+	messages <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 1}
+	messages <- amqp.Delivery{
+		Acknowledger: acknowledger,
+		DeliveryTag:  2,
+		Headers:      amqp.Table{headerRemainingRetries: int32(0)},
+	}
+	close(messages)
+
+	c.consume(context.Background(), messages, nil)
+
+	// the first message is republished with decreased retries, the second one has no retries left
+	assert.Len(t, queue.published, 1)
+	assert.Equal(t, 0, *queue.published[0].MaxRetries)
+	assert.Equal(t, []ackCall{
+		{tag: 1, ack: true},
+		{tag: 2, ack: true},
+	}, acknowledger.getCalls())
+}
+
+func TestBatchConsumer_StartValidatesOptions(t *testing.T) {
+	processor := BatchProcessorFunc(func(context.Context, []Message) error { return nil })
+
+	unsupported := func(modify func(o *BatchConsumerOptions)) *BatchConsumerOptions {
+		options := DefaultBatchConsumerOptions(1, 10, time.Second)
+		modify(options)
+		return options
+	}
+
+	for _, options := range []*BatchConsumerOptions{
+		DefaultBatchConsumerOptions(1, 0, time.Second),
+		DefaultBatchConsumerOptions(1, 10, 0),
+		unsupported(func(o *BatchConsumerOptions) {
+			o.Deduplication = DefaultDeduplicationOptions(newMemoryDeduplicationStore(), "deposits:")
+		}),
+		unsupported(func(o *BatchConsumerOptions) { o.PartitionKey = PartitionByHeader("x-account") }),
+		unsupported(func(o *BatchConsumerOptions) { o.Autoscaling = &AutoscalingOptions{} }),
+	} {
+		c := newBatchConsumer(NewMemoryBroker(), &fakeQueue{}, options, processor)
+		assert.Error(t, c.Start(context.Background()))
+	}
+}
@@ -0,0 +1,319 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+const defaultPublishChannelPoolSize = 8
+
+// ErrPublishNotConfirmed is returned when the broker negatively acknowledges a message published with confirmation.
+var ErrPublishNotConfirmed = errors.New("publishing not confirmed by the broker")
+
+// ErrUnroutable is returned when a mandatory message isn't routed to any queue, see PublishConfig.Mandatory.
+var ErrUnroutable = errors.New("message is unroutable")
+
+const (
+	channelPoolSizeKey                = "mq_channel_pool_size"
+	channelPoolInUseKey               = "mq_channel_pool_in_use"
+	channelPoolWaitDurationSecondsKey = "mq_channel_pool_wait_duration_seconds"
+	channelPoolOpenedTotalKey         = "mq_channel_pool_opened_total"
+)
+
+const (
+	labelPool = "pool"
+
+	publishChannelPool = "publish"
+	confirmChannelPool = "confirm"
+)
+
+// ChannelPoolMetric records the usage of the publishing channel pools, publishes with confirmation use their own pool.
+type ChannelPoolMetric interface {
+	Size(pool string, size int)
+	Acquired(pool string, wait time.Duration)
+	Released(pool string)
+	Opened(pool string)
+}
+
+type channelPoolMetric struct {
+	size                *prometheus.GaugeVec
+	inUse               *prometheus.GaugeVec
+	waitDurationSeconds *prometheus.HistogramVec
+	openedTotal         *prometheus.CounterVec
+}
+
+func NewChannelPoolMetric(
+	namespace string,
+	staticLabels prometheus.Labels,
+	reg prometheus.Registerer,
+) ChannelPoolMetric {
+	size := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      channelPoolSizeKey,
+		Help:      "Number of channels in the publishing channel pool.",
+	}, []string{labelPool})
+
+	inUse := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      channelPoolInUseKey,
+		Help:      "Number of publishing channels currently acquired by publishers.",
+	}, []string{labelPool})
+
+	waitDurationSeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      channelPoolWaitDurationSecondsKey,
+		Help:      "Time publishers waited to acquire a channel from the pool.",
+	}, []string{labelPool})
+
+	openedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      channelPoolOpenedTotalKey,
+		Help:      "Total number of publishing channels opened by the pool.",
+	}, []string{labelPool})
+
+	metrics.Register(staticLabels, reg, size, inUse, waitDurationSeconds, openedTotal)
+
+	return &channelPoolMetric{
+		size:                size,
+		inUse:               inUse,
+		waitDurationSeconds: waitDurationSeconds,
+		openedTotal:         openedTotal,
+	}
+}
+
+func (m *channelPoolMetric) Size(pool string, size int) {
+	m.size.WithLabelValues(pool).Set(float64(size))
+}
+
+func (m *channelPoolMetric) Acquired(pool string, wait time.Duration) {
+	m.inUse.WithLabelValues(pool).Inc()
+	m.waitDurationSeconds.WithLabelValues(pool).Observe(wait.Seconds())
+}
+
+func (m *channelPoolMetric) Released(pool string) {
+	m.inUse.WithLabelValues(pool).Dec()
+}
+
+func (m *channelPoolMetric) Opened(pool string) {
+	m.openedTotal.WithLabelValues(pool).Inc()
+}
+
+type NullableChannelPoolMetric struct{}
+
+func (NullableChannelPoolMetric) Size(_ string, _ int)               {}
+func (NullableChannelPoolMetric) Acquired(_ string, _ time.Duration) {}
+func (NullableChannelPoolMetric) Released(_ string)                  {}
+func (NullableChannelPoolMetric) Opened(_ string)                    {}
+
+// amqpChannel is the part of *amqp.Channel used by the pool
+type amqpChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// channelOpener opens a new channel of the current connection
+type channelOpener func() (amqpChannel, error)
+
+func connChannelOpener(conn *amqp.Connection) channelOpener {
+	return func() (amqpChannel, error) {
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		return ch, nil
+	}
+}
+
+// pooledChannel is a slot of the channel pool.
+// The underlying amqp channel is opened lazily and reopened whenever it gets closed
+// or belongs to a connection which has been replaced after reconnect.
+type pooledChannel struct {
+	ch         amqpChannel
+	closed     chan *amqp.Error
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+	generation uint64
+}
+
+func (pc *pooledChannel) isClosed() bool {
+	select {
+	case <-pc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// channelPool holds a fixed number of amqp channels used for publishing.
+// amqp.Channel is not safe for concurrent publishing, so each publisher
+// exclusively acquires a channel for the duration of a single publish.
+type channelPool struct {
+	name       string
+	mu         sync.RWMutex
+	open       channelOpener
+	generation uint64
+
+	slots  chan *pooledChannel
+	metric ChannelPoolMetric
+
+	// confirm puts the channels into confirm mode, see doConfirmed
+	confirm bool
+}
+
+func newChannelPool(name string, open channelOpener, size int, metric ChannelPoolMetric) *channelPool {
+	if size < 1 {
+		size = 1
+	}
+	if metric == nil {
+		metric = &NullableChannelPoolMetric{}
+	}
+
+	p := &channelPool{
+		name:   name,
+		open:   open,
+		slots:  make(chan *pooledChannel, size),
+		metric: metric,
+	}
+	for i := 0; i < size; i++ {
+		p.slots <- &pooledChannel{}
+	}
+	metric.Size(name, size)
+
+	return p
+}
+
+// reset makes the pool use the new connection.
+// Channels of the previous connection are reopened lazily on the next acquire.
+func (p *channelPool) reset(open channelOpener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.open = open
+	p.generation++
+}
+
+func (p *channelPool) acquire() (*pooledChannel, error) {
+	start := time.Now()
+	pc := <-p.slots
+	p.metric.Acquired(p.name, time.Since(start))
+
+	if err := p.ensureOpen(pc); err != nil {
+		p.release(pc)
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+func (p *channelPool) release(pc *pooledChannel) {
+	p.slots <- pc
+	p.metric.Released(p.name)
+}
+
+func (p *channelPool) ensureOpen(pc *pooledChannel) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if pc.ch != nil && pc.generation == p.generation && !pc.isClosed() {
+		return nil
+	}
+
+	if pc.ch != nil {
+		// the channel is either already closed or belongs to a dead connection
+		p.discard(pc)
+	}
+
+	ch, err := p.open()
+	if err != nil {
+		return fmt.Errorf("open publishing channel: %w", err)
+	}
+
+	if p.confirm {
+		if err := ch.Confirm(false); err != nil {
+			_ = ch.Close()
+			return fmt.Errorf("put publishing channel into confirm mode: %w", err)
+		}
+		pc.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+		// the broker sends the return of an unroutable mandatory message before its confirmation
+		pc.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+
+	pc.ch = ch
+	pc.closed = ch.NotifyClose(make(chan *amqp.Error, 1))
+	pc.generation = p.generation
+	p.metric.Opened(p.name)
+
+	return nil
+}
+
+// discard closes the channel of the slot, so it's reopened on the next acquire
+func (p *channelPool) discard(pc *pooledChannel) {
+	_ = pc.ch.Close()
+	pc.ch = nil
+}
+
+// do executes fn with a channel exclusively acquired from the pool.
+// The channel is discarded if fn fails, since its state is unknown then.
+func (p *channelPool) do(fn func(ch amqpChannel) error) error {
+	pc, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer p.release(pc)
+
+	if err := fn(pc.ch); err != nil {
+		p.discard(pc)
+		return err
+	}
+
+	return nil
+}
+
+// doConfirmed executes fn, which has to publish a single message, with a channel in confirm mode
+// and waits until the broker confirms the message.
+func (p *channelPool) doConfirmed(ctx context.Context, fn func(ch amqpChannel) error) error {
+	pc, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer p.release(pc)
+
+	if err := fn(pc.ch); err != nil {
+		p.discard(pc)
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		// the late confirmation would be taken by the next publisher, so the channel is discarded
+		p.discard(pc)
+		return fmt.Errorf("wait for publish confirmation: %w", ctx.Err())
+	case confirmation, ok := <-pc.confirms:
+		if !ok {
+			return errors.New("channel closed before publish confirmation")
+		}
+		if !confirmation.Ack {
+			return ErrPublishNotConfirmed
+		}
+
+		select {
+		case ret := <-pc.returns:
+			return fmt.Errorf("%w: exchange %s, routing key %s: %s",
+				ErrUnroutable, ret.Exchange, ret.RoutingKey, ret.ReplyText)
+		default:
+			return nil
+		}
+	}
+}
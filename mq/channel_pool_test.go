@@ -0,0 +1,193 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeChannel struct {
+	mu         sync.Mutex
+	published  int
+	publishErr error
+	closed     bool
+	closeChans []chan *amqp.Error
+	confirms   chan amqp.Confirmation
+}
+
+func (ch *fakeChannel) Publish(_, _ string, _, _ bool, _ amqp.Publishing) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if ch.publishErr != nil {
+		return ch.publishErr
+	}
+	ch.published++
+	return nil
+}
+
+func (ch *fakeChannel) Confirm(_ bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.confirms = confirm
+	return confirm
+}
+
+func (ch *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	return c
+}
+
+func (ch *fakeChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.closeChans = append(ch.closeChans, c)
+	return c
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if !ch.closed {
+		ch.closed = true
+		for _, c := range ch.closeChans {
+			close(c)
+		}
+	}
+	return nil
+}
+
+func (ch *fakeChannel) isClosed() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.closed
+}
+
+// fakeConnection records the channels opened by the pool
+type fakeConnection struct {
+	mu       sync.Mutex
+	channels []*fakeChannel
+}
+
+func (c *fakeConnection) open() (amqpChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := &fakeChannel{}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeConnection) opened() []*fakeChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*fakeChannel(nil), c.channels...)
+}
+
+func publishFake(ch amqpChannel) error {
+	return ch.Publish("", "deposits", false, false, amqp.Publishing{})
+}
+
+func TestChannelPool_ReusesChannels(t *testing.T) {
+	conn := &fakeConnection{}
+	pool := newChannelPool(publishChannelPool, conn.open, 2, nil)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pool.do(publishFake))
+	}
+
+	channels := conn.opened()
+	require.Len(t, channels, 2, "released channels must be reused")
+	assert.Equal(t, 10, channels[0].published+channels[1].published)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, pool.do(publishFake))
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, len(conn.opened()), 2, "no more channels than the pool size must be opened")
+}
+
+func TestChannelPool_DiscardsFailedChannel(t *testing.T) {
+	conn := &fakeConnection{}
+	pool := newChannelPool(publishChannelPool, conn.open, 1, nil)
+
+	errPublish := errors.New("publish failed")
+	err := pool.do(func(ch amqpChannel) error {
+		ch.(*fakeChannel).publishErr = errPublish
+		return publishFake(ch)
+	})
+	assert.True(t, errors.Is(err, errPublish))
+
+	require.NoError(t, pool.do(publishFake))
+
+	channels := conn.opened()
+	require.Len(t, channels, 2)
+	assert.True(t, channels[0].isClosed(), "the failed channel must be closed")
+	assert.Equal(t, 1, channels[1].published)
+}
+
+func TestChannelPool_ReopensClosedChannel(t *testing.T) {
+	conn := &fakeConnection{}
+	pool := newChannelPool(publishChannelPool, conn.open, 1, nil)
+
+	require.NoError(t, pool.do(publishFake))
+	// e.g. the broker closes the channel on a protocol error
+	require.NoError(t, conn.opened()[0].Close())
+
+	require.NoError(t, pool.do(publishFake))
+	assert.Len(t, conn.opened(), 2)
+
+	// channels of the previous connection are reopened after reset
+	next := &fakeConnection{}
+	pool.reset(next.open)
+	require.NoError(t, pool.do(publishFake))
+	assert.Len(t, next.opened(), 1)
+	assert.True(t, conn.opened()[1].isClosed())
+}
+
+func TestChannelPool_DoConfirmed(t *testing.T) {
+	conn := &fakeConnection{}
+	pool := newChannelPool(confirmChannelPool, conn.open, 1, nil)
+	pool.confirm = true
+
+	confirmWith := func(ack bool) func(ch amqpChannel) error {
+		return func(ch amqpChannel) error {
+			if err := publishFake(ch); err != nil {
+				return err
+			}
+			ch.(*fakeChannel).confirms <- amqp.Confirmation{Ack: ack}
+			return nil
+		}
+	}
+
+	assert.NoError(t, pool.doConfirmed(context.Background(), confirmWith(true)))
+	assert.True(t, errors.Is(pool.doConfirmed(context.Background(), confirmWith(false)), ErrPublishNotConfirmed))
+	require.Len(t, conn.opened(), 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := pool.doConfirmed(ctx, publishFake)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, conn.opened()[0].isClosed(), "the channel waiting for a confirmation must be discarded")
+
+	// the late confirmation of the discarded channel must not be taken by the next publish
+	assert.NoError(t, pool.doConfirmed(context.Background(), confirmWith(true)))
+	assert.Len(t, conn.opened(), 2)
+}
@@ -0,0 +1,71 @@
+package mq
+
+import (
+	"encoding/json"
+
+	ugorji "github.com/ugorji/go/codec"
+
+	"github.com/trustwallet/go-libs/pkg/protocodec"
+)
+
+const (
+	ContentTypeText     = "text/plain"
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// Codec encodes and decodes message bodies of typed publishers and consumers.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is the default codec, it uses encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackCodec encodes messages with MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := ugorji.NewEncoderBytes(&data, &ugorji.MsgpackHandle{}).Encode(v)
+	return data, err
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return ugorji.NewDecoderBytes(data, &ugorji.MsgpackHandle{}).Decode(v)
+}
+
+// ProtobufCodec encodes messages with Protocol Buffers.
+// Values must implement proto.Message, e.g. NewTypedPublisher[*pb.Event].
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	return protocodec.Marshal(v)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	return protocodec.Unmarshal(data, v)
+}
@@ -2,11 +2,11 @@ package mq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/trustwallet/go-libs/metrics"
-	"github.com/trustwallet/go-libs/pkg/nullable"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
@@ -14,15 +14,50 @@ import (
 
 const headerRemainingRetries = "x-remaining-retries"
 
+// processingTimeoutGrace is the time after the processing timeout from which a still running processor is reported
+const processingTimeoutGrace = 10 * time.Second
+
+// ErrProcessingTimeout is returned when the processing of a message exceeds ConsumerOptions.ProcessingTimeout.
+var ErrProcessingTimeout = errors.New("message processing timed out")
+
+// broker delivers messages to consumers, it's implemented by Client and MemoryBroker.
+// A subscription ends once the context is done or the stop channel is closed.
+type broker interface {
+	consume(ctx context.Context, stop <-chan struct{}, queue QueueName, prefetch int) (<-chan amqp.Delivery, error)
+	subscribe(ctx context.Context, stop <-chan struct{}, queue QueueName, prefetch int) (<-chan amqp.Delivery, qosFunc, error)
+	InspectQueue(name QueueName) (QueueState, error)
+	clientMetric() ClientMetric
+	HealthCheck() error
+}
+
+// qosFunc changes the prefetch count of a consumer
+type qosFunc func(prefetch int) error
+
 type consumer struct {
-	client *Client
+	broker broker
 
-	queue            Queue
-	messageProcessor MessageProcessor
-	options          *ConsumerOptions
+	queue     Queue
+	processor DeliveryProcessor
+	options   *ConsumerOptions
 
-	messages <-chan amqp.Delivery
 	stopChan chan struct{}
+
+	// latency is collected only for autoscaling
+	latency *latencyWindow
+}
+
+func newConsumer(broker broker, queue Queue, options *ConsumerOptions, processor DeliveryProcessor) *consumer {
+	// batch consumers have no delivery processor
+	if options.Deduplication != nil && processor != nil {
+		processor = newDeduplicatingProcessor(processor, options.Deduplication)
+	}
+
+	return &consumer{
+		broker:    broker,
+		queue:     queue,
+		processor: processor,
+		options:   options,
+	}
 }
 
 type Consumer interface {
@@ -44,15 +79,28 @@ func (f MessageProcessorFunc) Process(m Message) error {
 }
 
 func (c *consumer) Start(ctx context.Context) error {
-	c.stopChan = make(chan struct{})
+	if err := c.options.validate(); err != nil {
+		return fmt.Errorf("invalid consumer options: %w", err)
+	}
 
-	var err error
-	c.messages, err = c.messageChannel()
+	if c.options.Autoscaling != nil {
+		return c.startAutoscaling(ctx)
+	}
+
+	stop := make(chan struct{})
+	c.stopChan = stop
+
+	messages, err := c.messageChannel(ctx, stop, c.getSanitizedPrefetchCount())
 	if err != nil {
 		return fmt.Errorf("get message channel: %v", err)
 	}
-	for w := 1; w <= c.options.Workers; w++ {
-		go c.consume(ctx)
+
+	if c.options.PartitionKey != nil {
+		c.startOrdered(ctx, messages, stop)
+	} else {
+		for w := 1; w <= c.options.Workers; w++ {
+			go c.consume(ctx, messages, stop, nil)
+		}
 	}
 
 	log.Infof("Started %d MQ consumer workers for queue %s", c.options.Workers, c.queue.Name())
@@ -61,7 +109,6 @@ func (c *consumer) Start(ctx context.Context) error {
 }
 
 func (c *consumer) Reconnect(ctx context.Context) error {
-	c.messages = nil
 	if c.stopChan != nil {
 		close(c.stopChan)
 	}
@@ -74,7 +121,9 @@ func (c *consumer) Reconnect(ctx context.Context) error {
 	return nil
 }
 
-func (c *consumer) consume(ctx context.Context) {
+// consume processes messages until the context is done, the consumer is stopped by stop
+// or the worker is stopped by stopWorker. The channels are passed in, since Reconnect replaces those of the consumer.
+func (c *consumer) consume(ctx context.Context, messages <-chan amqp.Delivery, stop, stopWorker <-chan struct{}) {
 	queueName := string(c.queue.Name())
 
 	for {
@@ -82,58 +131,51 @@ func (c *consumer) consume(ctx context.Context) {
 		case <-ctx.Done():
 			log.Infof("Finished consuming queue %s", queueName)
 			return
-		case <-c.stopChan:
+		case <-stop:
 			log.Infof("Force stopped consuming queue %s", queueName)
 			return
-		case msg := <-c.messages:
+		case <-stopWorker:
+			return
+		case msg := <-messages:
 			if msg.Body == nil {
 				continue
 			}
+			c.broker.clientMetric().Delivered(c.queue.Name(), msg.Redelivered)
 
-			err := c.process(queueName, msg.Body)
+			msgCtx := ExtractContext(ctx, msg.Headers)
+			err := c.process(msgCtx, newDelivery(msg, c.getRemainingRetries(msg)))
 			if err != nil {
-				log.Error(err)
+				log.WithFields(LogFields(msgCtx)).Error(err)
 			}
 
-			if err != nil && c.options.RetryOnError {
-				time.Sleep(c.options.RetryDelay)
-				remainingRetries := c.getRemainingRetries(msg)
-
-				switch {
-				case remainingRetries > 0:
-					if err := c.queue.PublishWithConfig(msg.Body, PublishConfig{
-						MaxRetries: nullable.Int(int(remainingRetries - 1)),
-					}); err != nil {
-						log.Error(err)
-					}
-				case remainingRetries == 0:
-					break
-				default:
-					if err := msg.Reject(true); err != nil {
-						log.Error(err)
-					}
-					continue
-				}
+			outcome, delay := c.decide(err)
+			if !sleep(ctx, stop, delay) {
+				continue
 			}
 
-			if err := msg.Ack(false); err != nil {
-				log.Error(err)
-			}
+			c.settle(msg, outcome)
 		}
 	}
 }
 
-func (c *consumer) process(queueName string, body []byte) error {
+func (c *consumer) process(ctx context.Context, delivery Delivery) error {
 	metric := c.options.PerformanceMetric
 	if metric == nil {
 		metric = &metrics.NullablePerformanceMetric{}
 	}
 
 	defer metric.Duration(metric.Start())
-	err := c.messageProcessor.Process(body)
+	if c.latency != nil {
+		defer func(start time.Time) { c.latency.observe(time.Since(start)) }(time.Now())
+	}
+
+	err := processWithTimeout(ctx, c.options.ProcessingTimeout, func(ctx context.Context) error {
+		return c.processor.Process(ctx, delivery)
+	})
 
 	if err != nil {
 		metric.Failure()
+		countTimeout(metric, err)
 	} else {
 		metric.Success()
 	}
@@ -141,32 +183,43 @@ func (c *consumer) process(queueName string, body []byte) error {
 	return err
 }
 
-// messageChannel will create a new dedicated channel for this consumer to use
-func (c *consumer) messageChannel() (<-chan amqp.Delivery, error) {
-	mqChan, err := c.client.conn.Channel()
-	if err != nil {
-		return nil, fmt.Errorf("MQ issue. queue: %s, err: %w", string(c.queue.Name()), err)
+// processWithTimeout runs fn with a context cancelled after the timeout.
+// It always waits for fn to return, since an abandoned processor would keep running
+// while the message is retried. A warning is logged if fn ignores the cancellation.
+func processWithTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
 	}
 
-	err = mqChan.Qos(c.getSanitizedPrefetchCount(), 0, true)
-	if err != nil {
-		return nil, fmt.Errorf("MQ issue. queue: %s, err: %w", string(c.queue.Name()), err)
-	}
-
-	messageChannel, err := mqChan.Consume(
-		string(c.queue.Name()),
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("MQ issue" + err.Error() + " for queue: " + string(c.queue.Name()))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stuck := time.AfterFunc(timeout+processingTimeoutGrace, func() {
+		log.Warnf("Message processing still running %s after the timeout of %s, the processor ignores the context",
+			processingTimeoutGrace, timeout)
+	})
+	defer stuck.Stop()
+
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %v", ErrProcessingTimeout, timeout, err)
 	}
 
-	return messageChannel, nil
+	return err
+}
+
+func countTimeout(metric metrics.PerformanceMetric, err error) {
+	if !errors.Is(err, ErrProcessingTimeout) {
+		return
+	}
+	if timeoutMetric, ok := metric.(metrics.TimeoutMetric); ok {
+		timeoutMetric.Timeout()
+	}
+}
+
+// messageChannel will create a new dedicated channel for this consumer to use, it's closed on stop
+func (c *consumer) messageChannel(ctx context.Context, stop <-chan struct{}, prefetch int) (<-chan amqp.Delivery, error) {
+	return c.broker.consume(ctx, stop, c.queue.Name(), prefetch)
 }
 
 func (c *consumer) getSanitizedPrefetchCount() int {
@@ -192,7 +245,7 @@ func (c *consumer) getRemainingRetries(delivery amqp.Delivery) int32 {
 }
 
 func (c *consumer) HealthCheck() error {
-	if err := c.client.HealthCheck(); err != nil {
+	if err := c.broker.HealthCheck(); err != nil {
 		return fmt.Errorf("client health check: %v", err)
 	}
 
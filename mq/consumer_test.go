@@ -0,0 +1,88 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+type timeoutCountingMetric struct {
+	metrics.NullablePerformanceMetric
+	failures int
+	timeouts int
+}
+
+func (m *timeoutCountingMetric) Failure(_ ...string) {
+	m.failures++
+}
+
+func (m *timeoutCountingMetric) Timeout(_ ...string) {
+	m.timeouts++
+}
+
+func TestConsumer_ProcessTimeout(t *testing.T) {
+	metric := &timeoutCountingMetric{}
+	options := DefaultConsumerOptions(1)
+	options.ProcessingTimeout = 20 * time.Millisecond
+	options.PerformanceMetric = metric
+
+	c := &consumer{
+		queue:   &fakeQueue{},
+		options: options,
+		processor: DeliveryProcessorFunc(func(ctx context.Context, _ Delivery) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	}
+
+	err := c.process(context.Background(), Delivery{})
+	assert.True(t, errors.Is(err, ErrProcessingTimeout))
+	assert.Equal(t, 1, metric.failures)
+	assert.Equal(t, 1, metric.timeouts)
+}
+
+func TestProcessWithTimeout(t *testing.T) {
+	t.Run("no timeout", func(t *testing.T) {
+		err := processWithTimeout(context.Background(), 0, func(ctx context.Context) error {
+			_, hasDeadline := ctx.Deadline()
+			assert.False(t, hasDeadline)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("cancelled processor", func(t *testing.T) {
+		err := processWithTimeout(context.Background(), 10*time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.True(t, errors.Is(err, ErrProcessingTimeout))
+	})
+
+	t.Run("processor ignoring the context", func(t *testing.T) {
+		finished := false
+		err := processWithTimeout(context.Background(), 10*time.Millisecond, func(context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			finished = true
+			return nil
+		})
+		assert.NoError(t, err, "the processor has succeeded despite the timeout")
+		assert.True(t, finished, "the processor must not be abandoned")
+	})
+
+	t.Run("shutdown waits for processor", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := processWithTimeout(ctx, time.Minute, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
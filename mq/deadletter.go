@@ -0,0 +1,355 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// deadLetterBroker is implemented by Client and MemoryBroker
+type deadLetterBroker interface {
+	// fetch gets up to limit messages of the queue without acknowledging them,
+	// release returns the unacknowledged ones back to the queue.
+	fetch(ctx context.Context, queue QueueName, limit int) (deliveries []amqp.Delivery, release func() error, err error)
+	PublishWithConfirm(ctx context.Context, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error
+}
+
+// Death is an entry of the x-death header RabbitMQ adds to dead lettered messages.
+type Death struct {
+	Queue       QueueName
+	Reason      string
+	Exchange    ExchangeName
+	RoutingKeys []ExchangeKey
+	Count       int64
+	Time        time.Time
+}
+
+// DeadLetter is a message of a dead letter queue.
+type DeadLetter struct {
+	Delivery
+
+	// Deaths is the history of dead lettering, the latest death first.
+	Deaths []Death
+}
+
+// DeadLetterFilter selects dead lettered messages to peek or replay.
+type DeadLetterFilter func(d DeadLetter) bool
+
+// HeaderFilter selects messages having the header with the value, compared by its string representation.
+func HeaderFilter(header, value string) DeadLetterFilter {
+	return func(d DeadLetter) bool {
+		v, ok := d.Headers[header]
+		return ok && fmt.Sprint(v) == value
+	}
+}
+
+// JSONPathFilter selects messages with JSON bodies having the value at the dot separated path,
+// e.g. "transfer.coin" or "inputs.0.address". Values are compared by their string representation.
+func JSONPathFilter(path, value string) DeadLetterFilter {
+	return func(d DeadLetter) bool {
+		v, ok := jsonPath(d.Body, path)
+		return ok && v == value
+	}
+}
+
+// AllFilters selects messages matching all the filters.
+func AllFilters(filters ...DeadLetterFilter) DeadLetterFilter {
+	return func(d DeadLetter) bool {
+		for _, filter := range filters {
+			if !filter(d) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func jsonPath(body []byte, path string) (string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+
+	for _, field := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[field]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			value = v[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", false
+	case nil:
+		return "null", true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+type ReplayTarget int
+
+const (
+	// ReplayToQueue publishes messages directly to the queue they were dead lettered from.
+	ReplayToQueue ReplayTarget = iota
+	// ReplayToExchange publishes messages to the exchange with the routing key they were originally published with,
+	// so they are routed to every queue bound to the exchange by the key again.
+	ReplayToExchange
+)
+
+type ReplayOptions struct {
+	// Limit is the maximum number of messages fetched from the dead letter queue.
+	Limit int
+	// Filter selects messages to replay, nil selects all the fetched messages.
+	Filter DeadLetterFilter
+	Target ReplayTarget
+	// DryRun returns the messages which would be replayed, leaving them in the dead letter queue.
+	DryRun bool
+}
+
+// DeadLetterQueue inspects and replays messages of a dead letter queue.
+//
+// Messages are fetched without acknowledgement and returned back to the queue afterwards,
+// so they are marked as redelivered and other consumers of the queue don't get them meanwhile.
+type DeadLetterQueue struct {
+	name   QueueName
+	broker deadLetterBroker
+}
+
+func (c *Client) InitDeadLetterQueue(name QueueName) *DeadLetterQueue {
+	return &DeadLetterQueue{name: name, broker: c}
+}
+
+func (b *MemoryBroker) InitDeadLetterQueue(name QueueName) *DeadLetterQueue {
+	return &DeadLetterQueue{name: name, broker: b}
+}
+
+// Peek returns the messages matching the filter among the first limit messages of the queue.
+func (q *DeadLetterQueue) Peek(ctx context.Context, limit int, filter DeadLetterFilter) ([]DeadLetter, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit: %d", limit)
+	}
+
+	deliveries, release, err := q.broker.fetch(ctx, q.name, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []DeadLetter
+	for _, msg := range deliveries {
+		if d := newDeadLetter(msg); filter == nil || filter(d) {
+			result = append(result, d)
+		}
+	}
+
+	if err := release(); err != nil {
+		return nil, fmt.Errorf("release messages of queue %s: %w", q.name, err)
+	}
+
+	return result, nil
+}
+
+// Replay republishes the selected messages with reset retry counters and removes them from the dead letter queue.
+// It stops at the first failed publish, the remaining messages stay in the queue.
+// It returns the replayed messages, which may be replayed twice if their acknowledgement fails.
+func (q *DeadLetterQueue) Replay(ctx context.Context, options ReplayOptions) (replayed []DeadLetter, err error) {
+	if options.Limit <= 0 {
+		return nil, fmt.Errorf("invalid limit: %d", options.Limit)
+	}
+
+	deliveries, release, err := q.broker.fetch(ctx, q.name, options.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if releaseErr := release(); releaseErr != nil && err == nil {
+			err = fmt.Errorf("release messages of queue %s: %w", q.name, releaseErr)
+		}
+	}()
+
+	for _, msg := range deliveries {
+		d := newDeadLetter(msg)
+		if options.Filter != nil && !options.Filter(d) {
+			continue
+		}
+
+		exchange, key, err := d.replayRoute(options.Target)
+		if err != nil {
+			return replayed, err
+		}
+
+		if !options.DryRun {
+			err := q.broker.PublishWithConfirm(ctx, exchange, key, msg.Body, replayPublishConfig(msg))
+			if err != nil {
+				return replayed, fmt.Errorf("replay message %s: %w", msg.MessageId, err)
+			}
+
+			if err := msg.Ack(false); err != nil {
+				return replayed, fmt.Errorf("ack replayed message %s: %w", msg.MessageId, err)
+			}
+		}
+
+		replayed = append(replayed, d)
+	}
+
+	return replayed, nil
+}
+
+func (d DeadLetter) replayRoute(target ReplayTarget) (ExchangeName, ExchangeKey, error) {
+	if len(d.Deaths) == 0 {
+		return "", "", fmt.Errorf("message %s has no %s header", d.MessageID, headerDeath)
+	}
+
+	death := d.Deaths[0]
+	if target == ReplayToQueue {
+		return "", ExchangeKey(death.Queue), nil
+	}
+
+	if len(death.RoutingKeys) == 0 {
+		return "", "", fmt.Errorf("message %s has no original routing key", d.MessageID)
+	}
+
+	return death.Exchange, death.RoutingKeys[0], nil
+}
+
+// replayPublishConfig keeps the metadata of the message, dropping the dead lettering and retry headers
+func replayPublishConfig(msg amqp.Delivery) PublishConfig {
+	cfg := retryPublishConfig(msg, 0)
+	cfg.MaxRetries = nil
+
+	for k := range cfg.Headers {
+		if k == headerDeath || k == headerRemainingRetries ||
+			strings.HasPrefix(k, "x-first-death-") || strings.HasPrefix(k, "x-last-death-") {
+			delete(cfg.Headers, k)
+		}
+	}
+
+	return cfg
+}
+
+func newDeadLetter(msg amqp.Delivery) DeadLetter {
+	remainingRetries, _ := msg.Headers[headerRemainingRetries].(int32)
+
+	return DeadLetter{
+		Delivery: newDelivery(msg, remainingRetries),
+		Deaths:   parseDeaths(msg.Headers[headerDeath]),
+	}
+}
+
+func parseDeaths(header interface{}) []Death {
+	entries, _ := header.([]interface{})
+
+	deaths := make([]Death, 0, len(entries))
+	for _, entry := range entries {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		death := Death{}
+		if v, ok := table["queue"].(string); ok {
+			death.Queue = QueueName(v)
+		}
+		if v, ok := table["reason"].(string); ok {
+			death.Reason = v
+		}
+		if v, ok := table["exchange"].(string); ok {
+			death.Exchange = ExchangeName(v)
+		}
+		if v, ok := table["count"].(int64); ok {
+			death.Count = v
+		}
+		if v, ok := table["time"].(time.Time); ok {
+			death.Time = v
+		}
+		keys, _ := table["routing-keys"].([]interface{})
+		for _, key := range keys {
+			if v, ok := key.(string); ok {
+				death.RoutingKeys = append(death.RoutingKeys, ExchangeKey(v))
+			}
+		}
+
+		deaths = append(deaths, death)
+	}
+
+	return deaths
+}
+
+// fetch gets the messages one by one on a dedicated channel, closing it returns unacknowledged messages to the queue
+func (c *Client) fetch(_ context.Context, queue QueueName, limit int) ([]amqp.Delivery, func() error, error) {
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("open channel: %w", err)
+	}
+
+	var deliveries []amqp.Delivery
+	for len(deliveries) < limit {
+		msg, ok, err := ch.Get(string(queue), false)
+		if err != nil {
+			_ = ch.Close()
+			return nil, nil, fmt.Errorf("get message from queue %s: %w", queue, err)
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, msg)
+	}
+
+	return deliveries, ch.Close, nil
+}
+
+// fetch takes ready messages by a subscription which isn't dispatched to, cancelling it requeues unacknowledged messages
+func (b *MemoryBroker) fetch(_ context.Context, queue QueueName, limit int) ([]amqp.Delivery, func() error, error) {
+	b.mu.Lock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		b.mu.Unlock()
+		return nil, nil, fmt.Errorf("queue %s not found", queue)
+	}
+
+	limit = memoryPrefetch(limit)
+	sub := &memorySubscription{
+		broker:     b,
+		queue:      q,
+		prefetch:   limit,
+		deliveries: make(chan amqp.Delivery, limit),
+		unacked:    map[uint64]*memoryMessage{},
+	}
+	for len(q.ready) > 0 && len(sub.unacked) < limit {
+		msg := q.ready[0]
+		q.ready = q.ready[1:]
+		sub.deliver(msg)
+	}
+	b.mu.Unlock()
+
+	deliveries := make([]amqp.Delivery, 0, len(sub.deliveries))
+	for len(sub.deliveries) > 0 {
+		deliveries = append(deliveries, <-sub.deliveries)
+	}
+
+	return deliveries, func() error {
+		b.cancel(sub)
+		return nil
+	}, nil
+}
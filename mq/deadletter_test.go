@@ -0,0 +1,131 @@
+package mq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deadLetteredBroker(t *testing.T, messages map[string]string) *MemoryBroker {
+	broker := NewMemoryBroker()
+
+	dlx := broker.InitExchange("dlx")
+	require.NoError(t, dlx.Declare(ExchangeKindFanout))
+	dlq := broker.InitQueue("deposits.dlq")
+	require.NoError(t, dlq.Declare())
+	require.NoError(t, dlx.Bind([]Queue{dlq}))
+
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.DeclareWithConfig(DeclareConfig{
+		Args: map[string]interface{}{headerDeadLetterExchange: "dlx"},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := broker.InitConsumer("deposits", DefaultConsumerOptions(1), MessageProcessorFunc(func(Message) error {
+		return ErrPoisonMessage
+	}))
+	require.NoError(t, consumer.Start(ctx))
+
+	for body, coin := range messages {
+		maxRetries := 2
+		require.NoError(t, queue.PublishWithConfig([]byte(body), PublishConfig{
+			MaxRetries: &maxRetries,
+			Headers:    map[string]interface{}{"coin": coin},
+		}))
+	}
+	require.NoError(t, broker.WaitForNack(ctx, "deposits", len(messages)))
+
+	cancel()
+	require.Eventually(t, func() bool {
+		state, err := broker.InspectQueue("deposits")
+		return err == nil && state.Consumers == 0
+	}, time.Second, time.Millisecond)
+
+	return broker
+}
+
+func TestDeadLetterQueue_Peek(t *testing.T) {
+	broker := deadLetteredBroker(t, map[string]string{
+		`{"tx":{"id":"1","amount":10}}`: "btc",
+		`{"tx":{"id":"2","amount":20}}`: "eth",
+	})
+	ctx := context.Background()
+	dlq := broker.InitDeadLetterQueue("deposits.dlq")
+
+	all, err := dlq.Peek(ctx, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Len(t, all[0].Deaths, 1)
+	assert.Equal(t, QueueName("deposits"), all[0].Deaths[0].Queue)
+	assert.Equal(t, "rejected", all[0].Deaths[0].Reason)
+	assert.Equal(t, 2, all[0].RemainingRetries)
+
+	btc, err := dlq.Peek(ctx, 10, HeaderFilter("coin", "btc"))
+	require.NoError(t, err)
+	require.Len(t, btc, 1)
+	assert.Equal(t, Message(`{"tx":{"id":"1","amount":10}}`), btc[0].Body)
+
+	second, err := dlq.Peek(ctx, 10, AllFilters(JSONPathFilter("tx.amount", "20"), HeaderFilter("coin", "eth")))
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, Message(`{"tx":{"id":"2","amount":20}}`), second[0].Body)
+
+	// peeking leaves messages in the queue
+	assert.Equal(t, 2, broker.QueueLength("deposits.dlq"))
+
+	_, err = dlq.Peek(ctx, 0, nil)
+	assert.Error(t, err)
+}
+
+func TestDeadLetterQueue_Replay(t *testing.T) {
+	broker := deadLetteredBroker(t, map[string]string{
+		`{"id":"1"}`: "btc",
+		`{"id":"2"}`: "eth",
+	})
+	ctx := context.Background()
+	dlq := broker.InitDeadLetterQueue("deposits.dlq")
+
+	replayed, err := dlq.Replay(ctx, ReplayOptions{Limit: 10, Filter: JSONPathFilter("id", "1"), DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, 2, broker.QueueLength("deposits.dlq"))
+	assert.Zero(t, broker.QueueLength("deposits"))
+
+	replayed, err = dlq.Replay(ctx, ReplayOptions{Limit: 10, Filter: JSONPathFilter("id", "1")})
+	require.NoError(t, err)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, 1, broker.QueueLength("deposits.dlq"))
+	assert.Equal(t, 1, broker.QueueLength("deposits"))
+
+	deliveries, err := broker.consume(ctx, nil, "deposits", 1)
+	require.NoError(t, err)
+	msg := <-deliveries
+	assert.Equal(t, []byte(`{"id":"1"}`), msg.Body)
+	assert.Equal(t, "btc", msg.Headers["coin"])
+	assert.NotContains(t, msg.Headers, headerDeath)
+	assert.NotContains(t, msg.Headers, headerRemainingRetries)
+}
+
+func TestJSONPath(t *testing.T) {
+	body := []byte(`{"a":{"b":[{"c":"x"},{"c":12345678901234567890}]},"n":null}`)
+
+	for path, expected := range map[string]string{
+		"a.b.0.c": "x",
+		"a.b.1.c": "12345678901234567890",
+		"n":       "null",
+	} {
+		v, ok := jsonPath(body, path)
+		assert.True(t, ok, path)
+		assert.Equal(t, expected, v, path)
+	}
+
+	for _, path := range []string{"a", "a.b.2.c", "a.x", "a.b.c"} {
+		_, ok := jsonPath(body, path)
+		assert.False(t, ok, path)
+	}
+}
@@ -0,0 +1,244 @@
+package mq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+const (
+	dedupStateProcessing = "processing"
+	dedupStateCompleted  = "completed"
+
+	defaultDedupProcessingTTL = time.Minute
+	defaultDedupCompletedTTL  = 24 * time.Hour
+
+	duplicateMessagesTotalKey = "mq_duplicate_messages_total"
+)
+
+// errMessageInProgress is returned when a duplicate of the message is being processed by another worker.
+// The message is requeued, it's either skipped or processed once the other worker finishes or its lease expires.
+var errMessageInProgress = errors.New("message is being processed by another worker")
+
+// DeduplicationStore keeps the state of processed messages, *redis.Redis of cache/redis implements it.
+type DeduplicationStore interface {
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Get(ctx context.Context, key string, receiver interface{}) error
+	// CompareAndDelete deletes the key only if it holds the value.
+	CompareAndDelete(ctx context.Context, key string, value interface{}) (bool, error)
+}
+
+// IdempotencyKeyFunc extracts the idempotency key of a message.
+// An empty key disables deduplication for the message.
+type IdempotencyKeyFunc func(d Delivery) (string, error)
+
+// MessageIDKey uses the message ID as the idempotency key.
+func MessageIDKey(d Delivery) (string, error) {
+	return d.MessageID, nil
+}
+
+// HeaderKey uses a string header as the idempotency key.
+func HeaderKey(header string) IdempotencyKeyFunc {
+	return func(d Delivery) (string, error) {
+		value, ok := d.Headers[header]
+		if !ok {
+			return "", nil
+		}
+
+		key, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("header %s is %T, not a string", header, value)
+		}
+
+		return key, nil
+	}
+}
+
+// DeduplicationOptions enables idempotent processing of a consumer.
+//
+// A key is marked as processing with SetNX before the message is processed, and as completed afterwards.
+// A message whose key is completed is acknowledged without processing.
+// A message whose key is processing is requeued, since another worker handles its duplicate right now.
+// If a worker crashes, the processing mark expires after ProcessingTTL and the message is processed again,
+// so ProcessingTTL has to exceed ProcessingTimeout of the consumer, which is checked on Start.
+// If the processing fails, the mark is removed so that retries are processed. Every mark holds a unique token,
+// so a worker whose mark has expired never removes the mark of the worker processing the message now.
+// Messages settled for good with ErrDiscard or ErrDeadLetter are marked as completed, so their duplicates,
+// including the dead lettered message replayed within CompletedTTL, are skipped.
+type DeduplicationOptions struct {
+	Store DeduplicationStore
+	// KeyFunc defaults to MessageIDKey.
+	KeyFunc IdempotencyKeyFunc
+	// KeyPrefix namespaces the keys in the store, e.g. by the queue name.
+	KeyPrefix string
+
+	// ProcessingTTL and CompletedTTL default to 1 minute and 24 hours if they aren't positive.
+	ProcessingTTL time.Duration
+	CompletedTTL  time.Duration
+
+	Metric DeduplicationMetric
+}
+
+func DefaultDeduplicationOptions(store DeduplicationStore, keyPrefix string) *DeduplicationOptions {
+	return &DeduplicationOptions{
+		Store:         store,
+		KeyFunc:       MessageIDKey,
+		KeyPrefix:     keyPrefix,
+		ProcessingTTL: defaultDedupProcessingTTL,
+		CompletedTTL:  defaultDedupCompletedTTL,
+		Metric:        &NullableDeduplicationMetric{},
+	}
+}
+
+// DeduplicationMetric counts skipped duplicates by the state of the original message.
+type DeduplicationMetric interface {
+	Duplicate(state string)
+}
+
+type deduplicationMetric struct {
+	duplicatesTotal *prometheus.CounterVec
+}
+
+func NewDeduplicationMetric(
+	namespace string,
+	staticLabels prometheus.Labels,
+	reg prometheus.Registerer,
+) DeduplicationMetric {
+	duplicatesTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      duplicateMessagesTotalKey,
+		Help:      "Total number of duplicate messages by the state of the original message.",
+	}, []string{"state"})
+
+	metrics.Register(staticLabels, reg, duplicatesTotal)
+
+	return &deduplicationMetric{duplicatesTotal: duplicatesTotal}
+}
+
+func (m *deduplicationMetric) Duplicate(state string) {
+	m.duplicatesTotal.WithLabelValues(state).Inc()
+}
+
+type NullableDeduplicationMetric struct{}
+
+func (NullableDeduplicationMetric) Duplicate(_ string) {}
+
+// deduplicatingProcessor skips messages which have already been processed
+type deduplicatingProcessor struct {
+	processor DeliveryProcessor
+	options   *DeduplicationOptions
+}
+
+func newDeduplicatingProcessor(processor DeliveryProcessor, options *DeduplicationOptions) *deduplicatingProcessor {
+	return &deduplicatingProcessor{
+		processor: processor,
+		options:   options,
+	}
+}
+
+func (p *deduplicatingProcessor) Process(ctx context.Context, d Delivery) error {
+	keyFunc := p.options.KeyFunc
+	if keyFunc == nil {
+		keyFunc = MessageIDKey
+	}
+
+	key, err := keyFunc(d)
+	if err != nil {
+		return fmt.Errorf("%w: idempotency key: %v", ErrPoisonMessage, err)
+	}
+	if key == "" {
+		return p.processor.Process(ctx, d)
+	}
+	key = p.options.KeyPrefix + key
+
+	lease, err := newProcessingLease()
+	if err != nil {
+		return err
+	}
+
+	acquired, err := p.options.Store.SetNX(ctx, key, lease, p.options.processingTTL())
+	if err != nil {
+		return fmt.Errorf("mark message %s as processing: %w", key, err)
+	}
+	if !acquired {
+		return p.duplicate(ctx, key)
+	}
+
+	err = p.processor.Process(ctx, d)
+
+	// the context might be cancelled by the processing timeout, the state has to be stored anyway
+	storeCtx := context.Background()
+	if err != nil && !errors.Is(err, ErrDiscard) && !errors.Is(err, ErrDeadLetter) {
+		if _, err := p.options.Store.CompareAndDelete(storeCtx, key, lease); err != nil {
+			log.Errorf("Unmark message %s as processing: %v", key, err)
+		}
+		return err
+	}
+
+	if err := p.options.Store.Set(storeCtx, key, dedupStateCompleted, p.options.completedTTL()); err != nil {
+		// the processing mark expires, so a duplicate might be processed again after ProcessingTTL
+		log.Errorf("Mark message %s as completed: %v", key, err)
+	}
+
+	return err
+}
+
+func (p *deduplicatingProcessor) duplicate(ctx context.Context, key string) error {
+	var state string
+	if err := p.options.Store.Get(ctx, key, &state); err != nil {
+		// the mark might have expired in between, the message is retried
+		return fmt.Errorf("get state of message %s: %w", key, err)
+	}
+
+	if state == dedupStateCompleted {
+		p.metric().Duplicate(dedupStateCompleted)
+		log.Debugf("Skipped duplicate message %s", key)
+		return nil
+	}
+
+	p.metric().Duplicate(dedupStateProcessing)
+	return fmt.Errorf("%w: %s", errMessageInProgress, key)
+}
+
+// newProcessingLease returns the processing mark with a unique token
+func newProcessingLease() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate processing lease: %w", err)
+	}
+
+	return dedupStateProcessing + ":" + hex.EncodeToString(b), nil
+}
+
+func (o *DeduplicationOptions) processingTTL() time.Duration {
+	if o.ProcessingTTL <= 0 {
+		return defaultDedupProcessingTTL
+	}
+
+	return o.ProcessingTTL
+}
+
+func (o *DeduplicationOptions) completedTTL() time.Duration {
+	if o.CompletedTTL <= 0 {
+		return defaultDedupCompletedTTL
+	}
+
+	return o.CompletedTTL
+}
+
+func (p *deduplicatingProcessor) metric() DeduplicationMetric {
+	if p.options.Metric == nil {
+		return &NullableDeduplicationMetric{}
+	}
+
+	return p.options.Metric
+}
@@ -0,0 +1,210 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryDeduplicationStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func newMemoryDeduplicationStore() *memoryDeduplicationStore {
+	return &memoryDeduplicationStore{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (s *memoryDeduplicationStore) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[key]; ok {
+		return false, nil
+	}
+	s.values[key], _ = json.Marshal(value)
+	s.ttls[key] = ttl
+	return true, nil
+}
+
+func (s *memoryDeduplicationStore) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key], _ = json.Marshal(value)
+	s.ttls[key] = ttl
+	return nil
+}
+
+func (s *memoryDeduplicationStore) Get(ctx context.Context, key string, receiver interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		return errors.New("not found")
+	}
+	return json.Unmarshal(value, receiver)
+}
+
+func (s *memoryDeduplicationStore) CompareAndDelete(ctx context.Context, key string, value interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, _ := json.Marshal(value)
+	if string(s.values[key]) != string(data) {
+		return false, nil
+	}
+	delete(s.values, key)
+	return true, nil
+}
+
+type countingDeduplicationMetric struct {
+	mu         sync.Mutex
+	duplicates map[string]int
+}
+
+func (m *countingDeduplicationMetric) Duplicate(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duplicates[state]++
+}
+
+func TestDeduplicatingProcessor(t *testing.T) {
+	store := newMemoryDeduplicationStore()
+	metric := &countingDeduplicationMetric{duplicates: map[string]int{}}
+	options := DefaultDeduplicationOptions(store, "deposits:")
+	options.Metric = metric
+
+	var calls int
+	fail := true
+	p := newDeduplicatingProcessor(DeliveryProcessorFunc(func(context.Context, Delivery) error {
+		calls++
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}), options)
+
+	ctx := context.Background()
+	d := Delivery{MessageID: "1"}
+
+	// a failed message is processed again
+	assert.Error(t, p.Process(ctx, d))
+	assert.Empty(t, store.values)
+	fail = false
+	assert.NoError(t, p.Process(ctx, d))
+	assert.Equal(t, 2, calls)
+
+	// a completed message is skipped
+	assert.NoError(t, p.Process(ctx, d))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, metric.duplicates[dedupStateCompleted])
+
+	// a message in progress is requeued
+	_, _ = store.SetNX(ctx, "deposits:2", dedupStateProcessing, time.Minute)
+	err := p.Process(ctx, Delivery{MessageID: "2"})
+	assert.True(t, errors.Is(err, errMessageInProgress))
+	assert.Equal(t, 1, metric.duplicates[dedupStateProcessing])
+
+	// a message whose processing mark has been taken over after expiring doesn't remove the new mark
+	expiring := newDeduplicatingProcessor(DeliveryProcessorFunc(func(context.Context, Delivery) error {
+		_ = store.Set(ctx, "deposits:3", "processing:other-worker", time.Minute)
+		return errors.New("failed")
+	}), options)
+	assert.Error(t, expiring.Process(ctx, Delivery{MessageID: "3"}))
+	var state string
+	require.NoError(t, store.Get(ctx, "deposits:3", &state))
+	assert.Equal(t, "processing:other-worker", state)
+
+	// messages without a key are not deduplicated
+	assert.NoError(t, p.Process(ctx, Delivery{}))
+	assert.NoError(t, p.Process(ctx, Delivery{}))
+	assert.Equal(t, 4, calls)
+}
+
+func TestDeduplicatingProcessor_FinalOutcomes(t *testing.T) {
+	store := newMemoryDeduplicationStore()
+
+	var calls int
+	outcome := ErrDiscard
+	// the TTLs aren't set, so the defaults apply
+	p := newDeduplicatingProcessor(DeliveryProcessorFunc(func(context.Context, Delivery) error {
+		calls++
+		return outcome
+	}), &DeduplicationOptions{Store: store})
+
+	ctx := context.Background()
+	assert.True(t, errors.Is(p.Process(ctx, Delivery{MessageID: "1"}), ErrDiscard))
+	assert.NoError(t, p.Process(ctx, Delivery{MessageID: "1"}), "a discarded message must be skipped")
+	assert.Equal(t, defaultDedupCompletedTTL, store.ttls["1"])
+
+	outcome = fmt.Errorf("%w: unknown asset", ErrDeadLetter)
+	assert.True(t, errors.Is(p.Process(ctx, Delivery{MessageID: "2"}), ErrDeadLetter))
+	assert.NoError(t, p.Process(ctx, Delivery{MessageID: "2"}), "a dead lettered message must be skipped")
+	assert.Equal(t, 2, calls)
+
+	outcome = errors.New("failed")
+	store.ttls = map[string]time.Duration{}
+	assert.Error(t, p.Process(ctx, Delivery{MessageID: "3"}))
+	assert.Equal(t, defaultDedupProcessingTTL, store.ttls["3"])
+}
+
+func TestConsumer_DeduplicationRequiresLongerTTL(t *testing.T) {
+	options := DefaultConsumerOptions(1)
+	options.ProcessingTimeout = time.Minute
+	options.Deduplication = DefaultDeduplicationOptions(newMemoryDeduplicationStore(), "deposits:")
+
+	consumer := NewMemoryBroker().InitConsumer("deposits", options, MessageProcessorFunc(func(Message) error {
+		return nil
+	}))
+	assert.Error(t, consumer.Start(context.Background()))
+}
+
+func TestHeaderKey(t *testing.T) {
+	key, err := HeaderKey("x-idempotency-key")(Delivery{Headers: map[string]interface{}{"x-idempotency-key": "tx1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "tx1", key)
+
+	key, err = HeaderKey("x-idempotency-key")(Delivery{})
+	assert.NoError(t, err)
+	assert.Empty(t, key)
+
+	_, err = HeaderKey("x-idempotency-key")(Delivery{Headers: map[string]interface{}{"x-idempotency-key": int32(1)}})
+	assert.Error(t, err)
+}
+
+func TestConsumer_Deduplication(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.Declare())
+
+	var processed int32
+	options := DefaultConsumerOptions(1)
+	options.Deduplication = DefaultDeduplicationOptions(newMemoryDeduplicationStore(), "deposits:")
+
+	consumer := broker.InitConsumer("deposits", options, MessageProcessorFunc(func(Message) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, queue.PublishWithConfig([]byte("deposit"), PublishConfig{MessageID: "tx1"}))
+	}
+
+	require.NoError(t, broker.WaitForAck(ctx, "deposits", 2))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&processed))
+}
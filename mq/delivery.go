@@ -0,0 +1,93 @@
+package mq
+
+import (
+	"context"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Delivery is a consumed message together with its metadata.
+type Delivery struct {
+	Body Message
+
+	Headers         map[string]interface{}
+	Exchange        ExchangeName
+	RoutingKey      ExchangeKey
+	MessageID       string
+	CorrelationID   string
+	ReplyTo         string
+	ContentType     string
+	ContentEncoding string
+	AppID           string
+	Priority        uint8
+	Timestamp       time.Time
+	Redelivered     bool
+
+	// RemainingRetries is the number of retries left after a processing failure.
+	// A negative value is equal to infinite retries.
+	RemainingRetries int
+}
+
+func newDelivery(msg amqp.Delivery, remainingRetries int32) Delivery {
+	return Delivery{
+		Body:             msg.Body,
+		Headers:          msg.Headers,
+		Exchange:         ExchangeName(msg.Exchange),
+		RoutingKey:       ExchangeKey(msg.RoutingKey),
+		MessageID:        msg.MessageId,
+		CorrelationID:    msg.CorrelationId,
+		ReplyTo:          msg.ReplyTo,
+		ContentType:      msg.ContentType,
+		ContentEncoding:  msg.ContentEncoding,
+		AppID:            msg.AppId,
+		Priority:         msg.Priority,
+		Timestamp:        msg.Timestamp,
+		Redelivered:      msg.Redelivered,
+		RemainingRetries: int(remainingRetries),
+	}
+}
+
+// DeliveryProcessor is a MessageProcessor which has access to the message metadata.
+type DeliveryProcessor interface {
+	Process(ctx context.Context, delivery Delivery) error
+}
+
+// DeliveryProcessorFunc is an adapter to allow to use
+// an ordinary functions as mq DeliveryProcessor.
+type DeliveryProcessorFunc func(ctx context.Context, delivery Delivery) error
+
+func (f DeliveryProcessorFunc) Process(ctx context.Context, d Delivery) error {
+	return f(ctx, d)
+}
+
+// messageProcessorAdapter allows to run a MessageProcessor as a DeliveryProcessor
+type messageProcessorAdapter struct {
+	processor MessageProcessor
+}
+
+func (a messageProcessorAdapter) Process(_ context.Context, d Delivery) error {
+	return a.processor.Process(d.Body)
+}
+
+// retryPublishConfig keeps the metadata of the original message when it gets republished for a retry
+func retryPublishConfig(msg amqp.Delivery, remainingRetries int) PublishConfig {
+	headers := make(map[string]interface{}, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	return PublishConfig{
+		MaxRetries:      &remainingRetries,
+		DeliveryMode:    DeliveryMode(msg.DeliveryMode),
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		AppID:           msg.AppId,
+		Priority:        msg.Priority,
+		MessageID:       msg.MessageId,
+		CorrelationID:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Headers:         headers,
+		Timestamp:       msg.Timestamp,
+	}
+}
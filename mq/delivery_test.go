@@ -0,0 +1,56 @@
+package mq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDelivery(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	msg := amqp.Delivery{
+		Headers:       amqp.Table{"source": "api"},
+		Exchange:      "deposits",
+		RoutingKey:    "btc",
+		MessageId:     "msg-1",
+		CorrelationId: "corr-1",
+		ContentType:   ContentTypeJSON,
+		Timestamp:     ts,
+		Redelivered:   true,
+		Body:          []byte("{}"),
+	}
+
+	d := newDelivery(msg, 3)
+	assert.Equal(t, Delivery{
+		Body:             Message("{}"),
+		Headers:          map[string]interface{}{"source": "api"},
+		Exchange:         "deposits",
+		RoutingKey:       "btc",
+		MessageID:        "msg-1",
+		CorrelationID:    "corr-1",
+		ContentType:      ContentTypeJSON,
+		Timestamp:        ts,
+		Redelivered:      true,
+		RemainingRetries: 3,
+	}, d)
+}
+
+func TestRetryPublishConfig(t *testing.T) {
+	msg := amqp.Delivery{
+		Headers:      amqp.Table{headerRemainingRetries: int32(3), "source": "api"},
+		DeliveryMode: amqp.Transient,
+		MessageId:    "msg-1",
+	}
+
+	cfg := retryPublishConfig(msg, 2)
+	assert.Equal(t, 2, *cfg.MaxRetries)
+	assert.Equal(t, DeliveryModeTransient, cfg.DeliveryMode)
+	assert.Equal(t, "msg-1", cfg.MessageID)
+	assert.Equal(t, "api", cfg.Headers["source"])
+
+	// the original headers must not be modified
+	cfg.Headers["source"] = "retry"
+	assert.Equal(t, "api", msg.Headers["source"])
+}
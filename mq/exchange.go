@@ -1,6 +1,9 @@
 package mq
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type exchange struct {
 	name   ExchangeName
@@ -15,13 +18,25 @@ type Exchange interface {
 	PublishWithKey(body []byte, key ExchangeKey) error
 }
 
+// ExchangeConfigPublisher is implemented by the exchanges of Client and MemoryBroker.
+// It isn't part of Exchange to keep other implementations of Exchange, e.g. mocks, compatible.
+type ExchangeConfigPublisher interface {
+	PublishWithConfig(body []byte, key ExchangeKey, cfg PublishConfig) error
+}
+
+// ExchangeContextPublisher is implemented by the exchanges of Client and MemoryBroker.
+// PublishWithContext propagates the trace context and the request ID of the context to consumers.
+type ExchangeContextPublisher interface {
+	PublishWithContext(ctx context.Context, body []byte, key ExchangeKey, cfg PublishConfig) error
+}
+
 func (e *exchange) Declare(kind string) error {
-	return e.client.amqpChan.ExchangeDeclare(string(e.name), kind, true, false, false, false, nil)
+	return e.client.managementChannel().ExchangeDeclare(string(e.name), kind, true, false, false, false, nil)
 }
 
 func (e *exchange) Bind(queues []Queue) error {
 	for _, q := range queues {
-		err := e.client.amqpChan.QueueBind(string(q.Name()), "", string(e.name), false, nil)
+		err := e.client.managementChannel().QueueBind(string(q.Name()), "", string(e.name), false, nil)
 		if err != nil {
 			return err
 		}
@@ -32,7 +47,7 @@ func (e *exchange) Bind(queues []Queue) error {
 
 func (e *exchange) BindWithKey(queues []Queue, key ExchangeKey) error {
 	for _, q := range queues {
-		err := e.client.amqpChan.QueueBind(string(q.Name()), string(key), string(e.name), false, nil)
+		err := e.client.managementChannel().QueueBind(string(q.Name()), string(key), string(e.name), false, nil)
 		if err != nil {
 			return err
 		}
@@ -42,11 +57,19 @@ func (e *exchange) BindWithKey(queues []Queue, key ExchangeKey) error {
 }
 
 func (e *exchange) Publish(body []byte) error {
-	return publish(e.client.amqpChan, e.name, "", body)
+	return e.client.publish(e.name, "", body)
 }
 
 func (e *exchange) PublishWithKey(body []byte, key ExchangeKey) error {
-	return publish(e.client.amqpChan, e.name, key, body)
+	return e.client.publish(e.name, key, body)
+}
+
+func (e *exchange) PublishWithConfig(body []byte, key ExchangeKey, cfg PublishConfig) error {
+	return e.client.publishWithConfig(context.Background(), e.name, key, body, cfg)
+}
+
+func (e *exchange) PublishWithContext(ctx context.Context, body []byte, key ExchangeKey, cfg PublishConfig) error {
+	return e.client.publishWithConfig(ctx, e.name, key, body, cfg)
 }
 
 func (e *exchange) HealthCheck() error {
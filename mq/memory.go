@@ -0,0 +1,716 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	ExchangeKindDirect = amqp.ExchangeDirect
+	ExchangeKindFanout = amqp.ExchangeFanout
+	ExchangeKindTopic  = amqp.ExchangeTopic
+
+	headerDeadLetterExchange   = "x-dead-letter-exchange"
+	headerDeadLetterRoutingKey = "x-dead-letter-routing-key"
+	headerDeath                = "x-death"
+
+	// memoryMaxPrefetch limits the number of unacknowledged deliveries of a consumer without prefetch
+	memoryMaxPrefetch = 1024
+)
+
+// MemoryBroker is an in-memory implementation of the broker for unit tests.
+// Queues, exchanges and consumers initiated by MemoryBroker implement the same interfaces
+// as the ones of Client, so the code under test doesn't need a running RabbitMQ.
+//
+// It supports direct, fanout and topic exchanges, acks, nacks, redelivery,
+// dead letter exchanges and the retry semantics of the consumers.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	exchanges map[ExchangeName]*memoryExchange
+	queues    map[QueueName]*memoryQueue
+
+	// changed is closed and replaced on every ack or nack, so that waiters can observe it
+	changed chan struct{}
+
+	metric ClientMetric
+}
+
+type memoryExchange struct {
+	kind     string
+	bindings []memoryBinding
+}
+
+type memoryBinding struct {
+	queue QueueName
+	key   ExchangeKey
+}
+
+type memoryMessage struct {
+	exchange    ExchangeName
+	routingKey  ExchangeKey
+	publishing  amqp.Publishing
+	redelivered bool
+}
+
+type memoryQueue struct {
+	name             QueueName
+	args             map[string]interface{}
+	ready            []*memoryMessage
+	subscriptions    []*memorySubscription
+	nextSubscription int
+
+	published []Message
+	acked     int
+	nacked    int
+}
+
+// memorySubscription is the in-memory counterpart of a consumer channel
+type memorySubscription struct {
+	broker     *MemoryBroker
+	queue      *memoryQueue
+	prefetch   int
+	deliveries chan amqp.Delivery
+	lastTag    uint64
+	unacked    map[uint64]*memoryMessage
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		exchanges: map[ExchangeName]*memoryExchange{},
+		queues:    map[QueueName]*memoryQueue{},
+		changed:   make(chan struct{}),
+		metric:    &NullableClientMetric{},
+	}
+}
+
+func (b *MemoryBroker) InitQueue(name QueueName) Queue {
+	return &memoryQueueHandle{name: name, broker: b}
+}
+
+func (b *MemoryBroker) InitExchange(name ExchangeName) Exchange {
+	return &memoryExchangeHandle{name: name, broker: b}
+}
+
+func (b *MemoryBroker) InitConsumer(queueName QueueName, options *ConsumerOptions, processor MessageProcessor) Consumer {
+	return b.InitDeliveryConsumer(queueName, options, messageProcessorAdapter{processor: processor})
+}
+
+func (b *MemoryBroker) InitDeliveryConsumer(queueName QueueName, options *ConsumerOptions, processor DeliveryProcessor) Consumer {
+	return newConsumer(b, b.InitQueue(queueName), options, processor)
+}
+
+func (b *MemoryBroker) InitBatchConsumer(queueName QueueName, options *BatchConsumerOptions, processor BatchProcessor) Consumer {
+	return newBatchConsumer(b, b.InitQueue(queueName), options, processor)
+}
+
+func (b *MemoryBroker) StartConsumers(ctx context.Context, consumers ...Consumer) error {
+	for _, consumer := range consumers {
+		if err := consumer.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) clientMetric() ClientMetric {
+	return b.metric
+}
+
+func (b *MemoryBroker) HealthCheck() error {
+	return nil
+}
+
+// PublishedMessages returns bodies of all messages routed to the queue, including republished retries.
+func (b *MemoryBroker) PublishedMessages(queue QueueName) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil
+	}
+
+	return append([]Message(nil), q.published...)
+}
+
+// QueueLength returns the number of messages waiting to be delivered from the queue.
+func (b *MemoryBroker) QueueLength(queue QueueName) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return 0
+	}
+
+	return len(q.ready)
+}
+
+// WaitForAck blocks until at least n messages of the queue are acknowledged or the context is done.
+func (b *MemoryBroker) WaitForAck(ctx context.Context, queue QueueName, n int) error {
+	return b.waitFor(ctx, queue, func(q *memoryQueue) bool { return q.acked >= n })
+}
+
+// WaitForNack blocks until at least n messages of the queue are rejected or the context is done.
+func (b *MemoryBroker) WaitForNack(ctx context.Context, queue QueueName, n int) error {
+	return b.waitFor(ctx, queue, func(q *memoryQueue) bool { return q.nacked >= n })
+}
+
+func (b *MemoryBroker) waitFor(ctx context.Context, queue QueueName, done func(q *memoryQueue) bool) error {
+	for {
+		b.mu.Lock()
+		q, ok := b.queues[queue]
+		if !ok {
+			b.mu.Unlock()
+			return fmt.Errorf("queue %s not found", queue)
+		}
+		isDone := done(q)
+		changed := b.changed
+		b.mu.Unlock()
+
+		if isDone {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (b *MemoryBroker) notifyChanged() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *MemoryBroker) declareQueue(name QueueName, args map[string]interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.queues[name]; ok {
+		return
+	}
+
+	b.queues[name] = &memoryQueue{name: name, args: args}
+}
+
+func (b *MemoryBroker) declareExchange(name ExchangeName, kind string) error {
+	switch kind {
+	case ExchangeKindDirect, ExchangeKindFanout, ExchangeKindTopic:
+	default:
+		return fmt.Errorf("exchange kind %s is not supported", kind)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e, ok := b.exchanges[name]; ok {
+		if e.kind != kind {
+			return fmt.Errorf("exchange %s is already declared with kind %s", name, e.kind)
+		}
+		return nil
+	}
+
+	b.exchanges[name] = &memoryExchange{kind: kind}
+	return nil
+}
+
+func (b *MemoryBroker) bind(exchange ExchangeName, queue QueueName, key ExchangeKey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("exchange %s not found", exchange)
+	}
+	if _, ok := b.queues[queue]; !ok {
+		return fmt.Errorf("queue %s not found", queue)
+	}
+
+	for _, binding := range e.bindings {
+		if binding.queue == queue && binding.key == key {
+			return nil
+		}
+	}
+
+	e.bindings = append(e.bindings, memoryBinding{queue: queue, key: key})
+	return nil
+}
+
+// PublishWithConfirm publishes the message like Client.PublishWithConfirm, the message is always confirmed.
+func (b *MemoryBroker) PublishWithConfirm(ctx context.Context, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error {
+	return b.publish(ctx, exchange, key, body, cfg)
+}
+
+func (b *MemoryBroker) publish(ctx context.Context, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error {
+	cfg.Headers = InjectContext(ctx, cfg.Headers)
+	publishing := newPublishing(append([]byte{}, body...), cfg)
+	publishing.Headers = normalizeTable(publishing.Headers)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	routed, err := b.route(exchange, key, publishing)
+	if err != nil {
+		return err
+	}
+	if routed == 0 && cfg.Mandatory {
+		return fmt.Errorf("%w: exchange %s, routing key %s", ErrUnroutable, exchange, key)
+	}
+
+	return nil
+}
+
+// route delivers the message to all matching queues and returns their number,
+// unroutable messages are dropped like in RabbitMQ
+func (b *MemoryBroker) route(exchange ExchangeName, key ExchangeKey, publishing amqp.Publishing) (int, error) {
+	var queues []QueueName
+	if exchange == "" {
+		queues = append(queues, QueueName(key))
+	} else {
+		e, ok := b.exchanges[exchange]
+		if !ok {
+			return 0, fmt.Errorf("exchange %s not found", exchange)
+		}
+
+		seen := map[QueueName]bool{}
+		for _, binding := range e.bindings {
+			if seen[binding.queue] || !e.matches(binding.key, key) {
+				continue
+			}
+			seen[binding.queue] = true
+			queues = append(queues, binding.queue)
+		}
+	}
+
+	var routed int
+	for _, name := range queues {
+		q, ok := b.queues[name]
+		if !ok {
+			continue
+		}
+
+		q.published = append(q.published, publishing.Body)
+		q.ready = append(q.ready, &memoryMessage{
+			exchange:   exchange,
+			routingKey: key,
+			publishing: publishing,
+		})
+		q.dispatch()
+		routed++
+	}
+
+	return routed, nil
+}
+
+func (e *memoryExchange) matches(bindingKey, routingKey ExchangeKey) bool {
+	switch e.kind {
+	case ExchangeKindFanout:
+		return true
+	case ExchangeKindTopic:
+		return topicMatches(strings.Split(string(bindingKey), "."), strings.Split(string(routingKey), "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatches matches routing key words against binding key words,
+// where * substitutes exactly one word and # substitutes zero or more words
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+func (b *MemoryBroker) consume(ctx context.Context, stop <-chan struct{}, queue QueueName, prefetch int) (<-chan amqp.Delivery, error) {
+	deliveries, _, err := b.subscribe(ctx, stop, queue, prefetch)
+	return deliveries, err
+}
+
+// subscribe dispatches messages of the queue to the subscription until it's cancelled by the context or the stop channel
+func (b *MemoryBroker) subscribe(
+	ctx context.Context,
+	stop <-chan struct{},
+	queue QueueName,
+	prefetch int,
+) (<-chan amqp.Delivery, qosFunc, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil, nil, fmt.Errorf("queue %s not found", queue)
+	}
+
+	sub := &memorySubscription{
+		broker:     b,
+		queue:      q,
+		prefetch:   memoryPrefetch(prefetch),
+		deliveries: make(chan amqp.Delivery, memoryMaxPrefetch),
+		unacked:    map[uint64]*memoryMessage{},
+	}
+	q.subscriptions = append(q.subscriptions, sub)
+	q.dispatch()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		b.cancel(sub)
+	}()
+
+	setPrefetch := func(prefetch int) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		sub.prefetch = memoryPrefetch(prefetch)
+		q.dispatch()
+		return nil
+	}
+
+	return sub.deliveries, setPrefetch, nil
+}
+
+func memoryPrefetch(prefetch int) int {
+	if prefetch <= 0 || prefetch > memoryMaxPrefetch {
+		return memoryMaxPrefetch
+	}
+
+	return prefetch
+}
+
+// InspectQueue returns the number of ready messages and consumers of the queue.
+func (b *MemoryBroker) InspectQueue(name QueueName) (QueueState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		return QueueState{}, fmt.Errorf("queue %s not found", name)
+	}
+
+	return QueueState{Name: name, Messages: len(q.ready), Consumers: len(q.subscriptions)}, nil
+}
+
+// cancel stops the subscription and requeues its unacknowledged messages, like closing a channel does
+func (b *MemoryBroker) cancel(sub *memorySubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := sub.queue
+	for i, s := range q.subscriptions {
+		if s == sub {
+			q.subscriptions = append(q.subscriptions[:i], q.subscriptions[i+1:]...)
+			break
+		}
+	}
+
+	q.requeue(sub.take(sub.lastTag, true))
+	close(sub.deliveries)
+	q.dispatch()
+}
+
+// dispatch delivers ready messages to subscriptions with free prefetch capacity in round-robin order
+func (q *memoryQueue) dispatch() {
+	for len(q.ready) > 0 {
+		sub := q.nextFreeSubscription()
+		if sub == nil {
+			return
+		}
+
+		msg := q.ready[0]
+		q.ready = q.ready[1:]
+		sub.deliver(msg)
+	}
+}
+
+func (q *memoryQueue) nextFreeSubscription() *memorySubscription {
+	for i := 0; i < len(q.subscriptions); i++ {
+		idx := (q.nextSubscription + i) % len(q.subscriptions)
+		if sub := q.subscriptions[idx]; len(sub.unacked) < sub.prefetch {
+			q.nextSubscription = idx + 1
+			return sub
+		}
+	}
+
+	return nil
+}
+
+func (q *memoryQueue) requeue(messages []*memoryMessage) {
+	for _, msg := range messages {
+		msg.redelivered = true
+	}
+	q.ready = append(messages, q.ready...)
+}
+
+func (s *memorySubscription) deliver(msg *memoryMessage) {
+	s.lastTag++
+	s.unacked[s.lastTag] = msg
+
+	p := msg.publishing
+	// the buffer never blocks, since it's as large as the maximum prefetch limit
+	s.deliveries <- amqp.Delivery{
+		Acknowledger:    s,
+		Headers:         copyTable(p.Headers),
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		DeliveryTag:     s.lastTag,
+		Redelivered:     msg.redelivered,
+		Exchange:        string(msg.exchange),
+		RoutingKey:      string(msg.routingKey),
+		Body:            p.Body,
+	}
+}
+
+// take removes and returns unacknowledged messages by the delivery tag, ordered by the tag
+func (s *memorySubscription) take(tag uint64, multiple bool) []*memoryMessage {
+	if !multiple {
+		msg, ok := s.unacked[tag]
+		if !ok {
+			return nil
+		}
+		delete(s.unacked, tag)
+		return []*memoryMessage{msg}
+	}
+
+	var messages []*memoryMessage
+	for t := uint64(1); t <= tag; t++ {
+		if msg, ok := s.unacked[t]; ok {
+			messages = append(messages, msg)
+			delete(s.unacked, t)
+		}
+	}
+
+	return messages
+}
+
+func (s *memorySubscription) Ack(tag uint64, multiple bool) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	messages := s.take(tag, multiple)
+	if len(messages) == 0 {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+
+	s.queue.acked += len(messages)
+	s.broker.notifyChanged()
+	s.queue.dispatch()
+
+	return nil
+}
+
+func (s *memorySubscription) Nack(tag uint64, multiple bool, requeue bool) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	messages := s.take(tag, multiple)
+	if len(messages) == 0 {
+		return fmt.Errorf("unknown delivery tag %d", tag)
+	}
+
+	s.queue.nacked += len(messages)
+	if requeue {
+		s.queue.requeue(messages)
+	} else {
+		for _, msg := range messages {
+			s.broker.deadLetter(s.queue, msg, "rejected")
+		}
+	}
+
+	s.broker.notifyChanged()
+	s.queue.dispatch()
+
+	return nil
+}
+
+func (s *memorySubscription) Reject(tag uint64, requeue bool) error {
+	return s.Nack(tag, false, requeue)
+}
+
+// deadLetter republishes the message to the dead letter exchange of the queue, if there is one
+func (b *MemoryBroker) deadLetter(q *memoryQueue, msg *memoryMessage, reason string) {
+	exchange, ok := q.args[headerDeadLetterExchange].(string)
+	if !ok {
+		return
+	}
+
+	key := msg.routingKey
+	if dlKey, ok := q.args[headerDeadLetterRoutingKey].(string); ok {
+		key = ExchangeKey(dlKey)
+	}
+
+	publishing := msg.publishing
+	publishing.Headers = copyTable(publishing.Headers)
+	publishing.Headers[headerDeath] = appendDeath(publishing.Headers[headerDeath], amqp.Table{
+		"queue":        string(q.name),
+		"reason":       reason,
+		"exchange":     string(msg.exchange),
+		"routing-keys": []interface{}{string(msg.routingKey)},
+		"count":        int64(1),
+	})
+
+	// errors are ignored like RabbitMQ drops messages dead lettered to a missing exchange
+	_, _ = b.route(ExchangeName(exchange), key, publishing)
+}
+
+// appendDeath adds the death to the x-death header, increasing the count if the message died the same way before
+func appendDeath(header interface{}, death amqp.Table) []interface{} {
+	deaths, _ := header.([]interface{})
+	for i, d := range deaths {
+		table, ok := d.(amqp.Table)
+		if !ok || table["queue"] != death["queue"] || table["reason"] != death["reason"] {
+			continue
+		}
+
+		count, _ := table["count"].(int64)
+		updated := copyTable(table)
+		updated["count"] = count + 1
+
+		result := append([]interface{}{updated}, deaths[:i]...)
+		return append(result, deaths[i+1:]...)
+	}
+
+	return append([]interface{}{death}, deaths...)
+}
+
+func copyTable(t amqp.Table) amqp.Table {
+	result := make(amqp.Table, len(t))
+	for k, v := range t {
+		result[k] = v
+	}
+	return result
+}
+
+// normalizeTable converts header values to the types they are decoded to after a roundtrip through RabbitMQ
+func normalizeTable(t map[string]interface{}) amqp.Table {
+	result := make(amqp.Table, len(t))
+	for k, v := range t {
+		result[k] = normalizeValue(v)
+	}
+	return result
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case int:
+		return int32(value)
+	case map[string]interface{}:
+		return normalizeTable(value)
+	case amqp.Table:
+		return normalizeTable(value)
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = normalizeValue(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+type memoryQueueHandle struct {
+	name   QueueName
+	broker *MemoryBroker
+}
+
+func (q *memoryQueueHandle) Name() QueueName {
+	return q.name
+}
+
+func (q *memoryQueueHandle) Declare() error {
+	return q.DeclareWithConfig(DeclareConfig{Durable: true})
+}
+
+func (q *memoryQueueHandle) DeclareWithConfig(cfg DeclareConfig) error {
+	q.broker.declareQueue(q.name, cfg.Args)
+	return nil
+}
+
+func (q *memoryQueueHandle) Publish(body []byte) error {
+	return q.PublishWithConfig(body, PublishConfig{})
+}
+
+func (q *memoryQueueHandle) PublishWithConfig(body []byte, cfg PublishConfig) error {
+	return q.broker.publish(context.Background(), "", ExchangeKey(q.name), body, cfg)
+}
+
+func (q *memoryQueueHandle) PublishWithContext(ctx context.Context, body []byte, cfg PublishConfig) error {
+	return q.broker.publish(ctx, "", ExchangeKey(q.name), body, cfg)
+}
+
+type memoryExchangeHandle struct {
+	name   ExchangeName
+	broker *MemoryBroker
+}
+
+func (e *memoryExchangeHandle) Declare(kind string) error {
+	if e.name == "" {
+		return errors.New("the default exchange cannot be declared")
+	}
+	return e.broker.declareExchange(e.name, kind)
+}
+
+func (e *memoryExchangeHandle) Bind(queues []Queue) error {
+	return e.BindWithKey(queues, "")
+}
+
+func (e *memoryExchangeHandle) BindWithKey(queues []Queue, key ExchangeKey) error {
+	for _, q := range queues {
+		if err := e.broker.bind(e.name, q.Name(), key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *memoryExchangeHandle) Publish(body []byte) error {
+	return e.PublishWithConfig(body, "", PublishConfig{})
+}
+
+func (e *memoryExchangeHandle) PublishWithKey(body []byte, key ExchangeKey) error {
+	return e.PublishWithConfig(body, key, PublishConfig{})
+}
+
+func (e *memoryExchangeHandle) PublishWithConfig(body []byte, key ExchangeKey, cfg PublishConfig) error {
+	return e.broker.publish(context.Background(), e.name, key, body, cfg)
+}
+
+func (e *memoryExchangeHandle) PublishWithContext(ctx context.Context, body []byte, key ExchangeKey, cfg PublishConfig) error {
+	return e.broker.publish(ctx, e.name, key, body, cfg)
+}
@@ -0,0 +1,194 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBroker_Routing(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	btc, eth, all := broker.InitQueue("btc"), broker.InitQueue("eth"), broker.InitQueue("all")
+	for _, q := range []Queue{btc, eth, all} {
+		require.NoError(t, q.Declare())
+	}
+
+	direct := broker.InitExchange("direct")
+	require.NoError(t, direct.Declare(ExchangeKindDirect))
+	require.NoError(t, direct.BindWithKey([]Queue{btc}, "btc"))
+
+	fanout := broker.InitExchange("fanout")
+	require.NoError(t, fanout.Declare(ExchangeKindFanout))
+	require.NoError(t, fanout.Bind([]Queue{btc, eth}))
+
+	topic := broker.InitExchange("topic")
+	require.NoError(t, topic.Declare(ExchangeKindTopic))
+	require.NoError(t, topic.BindWithKey([]Queue{eth}, "*.eth.*"))
+	require.NoError(t, topic.BindWithKey([]Queue{all}, "deposit.#"))
+
+	require.NoError(t, direct.PublishWithKey([]byte("direct-btc"), "btc"))
+	require.NoError(t, direct.PublishWithKey([]byte("direct-eth"), "eth"))
+	require.NoError(t, fanout.Publish([]byte("fanout")))
+	require.NoError(t, topic.PublishWithKey([]byte("topic-eth"), "deposit.eth.confirmed"))
+	require.NoError(t, topic.PublishWithKey([]byte("topic-deposit"), "deposit"))
+	require.NoError(t, btc.Publish([]byte("default")))
+
+	assert.Equal(t, []Message{Message("direct-btc"), Message("fanout"), Message("default")}, broker.PublishedMessages("btc"))
+	assert.Equal(t, []Message{Message("fanout"), Message("topic-eth")}, broker.PublishedMessages("eth"))
+	assert.Equal(t, []Message{Message("topic-eth"), Message("topic-deposit")}, broker.PublishedMessages("all"))
+
+	assert.Error(t, broker.InitExchange("missing").Publish([]byte("x")))
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		matches      bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.#", "a", true},
+		{"a.#", "a.b.c", true},
+		{"#.c", "a.b.c", true},
+		{"#", "", true},
+		{"*.b.#", "a.b", true},
+		{"*.b.#", "b", false},
+	}
+
+	e := &memoryExchange{kind: ExchangeKindTopic}
+	for _, tt := range tests {
+		assert.Equal(t, tt.matches, e.matches(ExchangeKey(tt.pattern), ExchangeKey(tt.key)), "%s vs %s", tt.pattern, tt.key)
+	}
+}
+
+func TestMemoryBroker_ConsumerRetries(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.Declare())
+
+	var attempts int32
+	options := DefaultConsumerOptions(1)
+	options.RetryDelay = 0
+	options.MaxRetries = 2
+
+	consumer := broker.InitDeliveryConsumer("deposits", options, DeliveryProcessorFunc(
+		func(_ context.Context, d Delivery) error {
+			atomic.AddInt32(&attempts, 1)
+			if d.RemainingRetries > 0 {
+				return errors.New("not yet")
+			}
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+
+	require.NoError(t, queue.Publish([]byte("deposit")))
+
+	// the original message and two retries are acknowledged
+	require.NoError(t, broker.WaitForAck(ctx, "deposits", 3))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Len(t, broker.PublishedMessages("deposits"), 3)
+}
+
+func TestMemoryBroker_Redelivery(t *testing.T) {
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.InitQueue("q").Declare())
+	require.NoError(t, broker.InitQueue("q").Publish([]byte("msg")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deliveries, err := broker.consume(ctx, nil, "q", 1)
+	require.NoError(t, err)
+
+	first := <-deliveries
+	assert.False(t, first.Redelivered)
+	require.NoError(t, first.Nack(false, true))
+
+	second := <-deliveries
+	assert.True(t, second.Redelivered)
+	assert.Equal(t, []byte("msg"), second.Body)
+	require.NoError(t, second.Ack(false))
+	assert.Error(t, second.Ack(false), "double ack must fail")
+
+	assert.NoError(t, broker.WaitForNack(ctx, "q", 1))
+	assert.NoError(t, broker.WaitForAck(ctx, "q", 1))
+}
+
+func TestMemoryBroker_DeadLetter(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	dlx := broker.InitExchange("dlx")
+	require.NoError(t, dlx.Declare(ExchangeKindFanout))
+	dlq := broker.InitQueue("deposits.dlq")
+	require.NoError(t, dlq.Declare())
+	require.NoError(t, dlx.Bind([]Queue{dlq}))
+
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.DeclareWithConfig(DeclareConfig{
+		Durable: true,
+		Args:    map[string]interface{}{"x-dead-letter-exchange": "dlx"},
+	}))
+
+	options := DefaultConsumerOptions(1)
+	consumer := broker.InitConsumer("deposits", options, MessageProcessorFunc(func(Message) error {
+		return ErrPoisonMessage
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+	require.NoError(t, queue.Publish([]byte("invalid")))
+
+	require.NoError(t, broker.WaitForNack(ctx, "deposits", 1))
+	assert.Equal(t, []Message{Message("invalid")}, broker.PublishedMessages("deposits.dlq"))
+
+	deliveries, err := broker.consume(ctx, nil, "deposits.dlq", 1)
+	require.NoError(t, err)
+	dead := <-deliveries
+
+	deaths, ok := dead.Headers["x-death"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, deaths, 1)
+	assert.Equal(t, "deposits", deaths[0].(amqp.Table)["queue"])
+	assert.Equal(t, "rejected", deaths[0].(amqp.Table)["reason"])
+}
+
+func TestMemoryBroker_ReconnectCancelsSubscription(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.Declare())
+
+	var processed int32
+	consumer := broker.InitConsumer("deposits", DefaultConsumerOptions(1), MessageProcessorFunc(func(Message) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	}))
+
+	ctx := context.Background()
+	require.NoError(t, consumer.Start(ctx))
+	require.NoError(t, consumer.Reconnect(ctx))
+
+	require.Eventually(t, func() bool {
+		state, err := broker.InspectQueue("deposits")
+		return err == nil && state.Consumers == 1
+	}, time.Second, 10*time.Millisecond, "the previous subscription must be cancelled")
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, queue.Publish([]byte("deposit")))
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.WaitForAck(waitCtx, "deposits", 4))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&processed))
+}
@@ -0,0 +1,316 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/trustwallet/go-libs/metrics"
+)
+
+const (
+	publishedTotalKey         = "mq_published_total"
+	publishDurationSecondsKey = "mq_publish_duration_seconds"
+	deliveredTotalKey         = "mq_delivered_total"
+	redeliveredTotalKey       = "mq_redelivered_total"
+	ackedTotalKey             = "mq_acked_total"
+	nackedTotalKey            = "mq_nacked_total"
+	retriedTotalKey           = "mq_retried_total"
+	discardedTotalKey         = "mq_discarded_total"
+	deadLetteredTotalKey      = "mq_dead_lettered_total"
+	reconnectionAttemptsKey   = "mq_reconnection_attempts_total"
+	queueMessagesKey          = "mq_queue_messages"
+	queueConsumersKey         = "mq_queue_consumers"
+	consumerWorkersKey        = "mq_consumer_workers"
+)
+
+const (
+	labelExchange = "exchange"
+	labelQueue    = "queue"
+	labelStatus   = "status"
+	labelRequeue  = "requeue"
+	statusSuccess = "success"
+	statusError   = "error"
+
+	serverNamedQueuePrefix = "amq.gen-"
+)
+
+// ClientMetric records the operations of the client, its publishers and consumers.
+type ClientMetric interface {
+	Published(exchange ExchangeName, key ExchangeKey, duration time.Duration, err error)
+	Delivered(queue QueueName, redelivered bool)
+	Acked(queue QueueName, count int)
+	Nacked(queue QueueName, requeue bool)
+	Retried(queue QueueName)
+	Discarded(queue QueueName)
+	DeadLettered(queue QueueName)
+	ReconnectionAttempt(err error)
+	QueueInspected(state QueueState)
+	ConsumerWorkers(queue QueueName, workers int)
+}
+
+type clientMetric struct {
+	publishedTotal         *prometheus.CounterVec
+	publishDurationSeconds *prometheus.HistogramVec
+	deliveredTotal         *prometheus.CounterVec
+	redeliveredTotal       *prometheus.CounterVec
+	ackedTotal             *prometheus.CounterVec
+	nackedTotal            *prometheus.CounterVec
+	retriedTotal           *prometheus.CounterVec
+	discardedTotal         *prometheus.CounterVec
+	deadLetteredTotal      *prometheus.CounterVec
+	reconnectionAttempts   *prometheus.CounterVec
+	queueMessages          *prometheus.GaugeVec
+	queueConsumers         *prometheus.GaugeVec
+	consumerWorkers        *prometheus.GaugeVec
+}
+
+func NewClientMetric(
+	namespace string,
+	staticLabels prometheus.Labels,
+	reg prometheus.Registerer,
+) ClientMetric {
+	publishedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      publishedTotalKey,
+		Help:      "Total number of published messages by exchange, or queue for the default exchange, and status.",
+	}, []string{labelExchange, labelQueue, labelStatus})
+
+	publishDurationSeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      publishDurationSecondsKey,
+		Help:      "Duration of publishing a message, including the wait for a publishing channel.",
+	}, []string{labelExchange, labelQueue})
+
+	deliveredTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      deliveredTotalKey,
+		Help:      "Total number of messages delivered to consumers.",
+	}, []string{labelQueue})
+
+	redeliveredTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      redeliveredTotalKey,
+		Help:      "Total number of messages delivered to consumers again by the broker.",
+	}, []string{labelQueue})
+
+	ackedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      ackedTotalKey,
+		Help:      "Total number of successfully processed and acknowledged messages.",
+	}, []string{labelQueue})
+
+	nackedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      nackedTotalKey,
+		Help:      "Total number of negatively acknowledged messages.",
+	}, []string{labelQueue, labelRequeue})
+
+	retriedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      retriedTotalKey,
+		Help:      "Total number of messages republished for a retry.",
+	}, []string{labelQueue})
+
+	discardedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      discardedTotalKey,
+		Help:      "Total number of failed messages acknowledged without a retry.",
+	}, []string{labelQueue})
+
+	deadLetteredTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      deadLetteredTotalKey,
+		Help:      "Total number of messages rejected to the dead letter exchange of the queue.",
+	}, []string{labelQueue})
+
+	reconnectionAttempts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      reconnectionAttemptsKey,
+		Help:      "Total number of reconnection attempts by status.",
+	}, []string{labelStatus})
+
+	queueMessages := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      queueMessagesKey,
+		Help:      "Number of messages ready for delivery in the queue.",
+	}, []string{labelQueue})
+
+	queueConsumers := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      queueConsumersKey,
+		Help:      "Number of consumers of the queue.",
+	}, []string{labelQueue})
+
+	consumerWorkers := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      consumerWorkersKey,
+		Help:      "Number of running workers of the consumers of the queue.",
+	}, []string{labelQueue})
+
+	metrics.Register(staticLabels, reg,
+		publishedTotal, publishDurationSeconds,
+		deliveredTotal, redeliveredTotal, ackedTotal, nackedTotal, retriedTotal, discardedTotal, deadLetteredTotal,
+		reconnectionAttempts, queueMessages, queueConsumers, consumerWorkers,
+	)
+
+	return &clientMetric{
+		publishedTotal:         publishedTotal,
+		publishDurationSeconds: publishDurationSeconds,
+		deliveredTotal:         deliveredTotal,
+		redeliveredTotal:       redeliveredTotal,
+		ackedTotal:             ackedTotal,
+		nackedTotal:            nackedTotal,
+		retriedTotal:           retriedTotal,
+		discardedTotal:         discardedTotal,
+		deadLetteredTotal:      deadLetteredTotal,
+		reconnectionAttempts:   reconnectionAttempts,
+		queueMessages:          queueMessages,
+		queueConsumers:         queueConsumers,
+		consumerWorkers:        consumerWorkers,
+	}
+}
+
+func (m *clientMetric) Published(exchange ExchangeName, key ExchangeKey, duration time.Duration, err error) {
+	queue := publishedQueueLabel(exchange, key)
+	m.publishedTotal.WithLabelValues(string(exchange), queue, statusLabel(err)).Inc()
+	m.publishDurationSeconds.WithLabelValues(string(exchange), queue).Observe(duration.Seconds())
+}
+
+// publishedQueueLabel returns the queue of publishes to the default exchange, whose routing key is the queue name.
+// Routing keys of other exchanges are free-form, so they aren't used as labels.
+// Server-named queues, e.g. RPC reply queues, share a single label.
+func publishedQueueLabel(exchange ExchangeName, key ExchangeKey) string {
+	switch {
+	case exchange != "":
+		return ""
+	case strings.HasPrefix(string(key), serverNamedQueuePrefix):
+		return serverNamedQueuePrefix
+	default:
+		return string(key)
+	}
+}
+
+func (m *clientMetric) Delivered(queue QueueName, redelivered bool) {
+	m.deliveredTotal.WithLabelValues(string(queue)).Inc()
+	if redelivered {
+		m.redeliveredTotal.WithLabelValues(string(queue)).Inc()
+	}
+}
+
+func (m *clientMetric) Acked(queue QueueName, count int) {
+	m.ackedTotal.WithLabelValues(string(queue)).Add(float64(count))
+}
+
+func (m *clientMetric) Nacked(queue QueueName, requeue bool) {
+	m.nackedTotal.WithLabelValues(string(queue), strconv.FormatBool(requeue)).Inc()
+}
+
+func (m *clientMetric) Retried(queue QueueName) {
+	m.retriedTotal.WithLabelValues(string(queue)).Inc()
+}
+
+func (m *clientMetric) Discarded(queue QueueName) {
+	m.discardedTotal.WithLabelValues(string(queue)).Inc()
+}
+
+func (m *clientMetric) DeadLettered(queue QueueName) {
+	m.deadLetteredTotal.WithLabelValues(string(queue)).Inc()
+}
+
+func (m *clientMetric) ReconnectionAttempt(err error) {
+	m.reconnectionAttempts.WithLabelValues(statusLabel(err)).Inc()
+}
+
+func (m *clientMetric) QueueInspected(state QueueState) {
+	m.queueMessages.WithLabelValues(string(state.Name)).Set(float64(state.Messages))
+	m.queueConsumers.WithLabelValues(string(state.Name)).Set(float64(state.Consumers))
+}
+
+func (m *clientMetric) ConsumerWorkers(queue QueueName, workers int) {
+	m.consumerWorkers.WithLabelValues(string(queue)).Set(float64(workers))
+}
+
+func statusLabel(err error) string {
+	if err != nil {
+		return statusError
+	}
+
+	return statusSuccess
+}
+
+type NullableClientMetric struct{}
+
+func (NullableClientMetric) Published(_ ExchangeName, _ ExchangeKey, _ time.Duration, _ error) {}
+func (NullableClientMetric) Delivered(_ QueueName, _ bool)                                     {}
+func (NullableClientMetric) Acked(_ QueueName, _ int)                                          {}
+func (NullableClientMetric) Nacked(_ QueueName, _ bool)                                        {}
+func (NullableClientMetric) Retried(_ QueueName)                                               {}
+func (NullableClientMetric) Discarded(_ QueueName)                                             {}
+func (NullableClientMetric) DeadLettered(_ QueueName)                                          {}
+func (NullableClientMetric) ReconnectionAttempt(_ error)                                       {}
+func (NullableClientMetric) QueueInspected(_ QueueState)                                       {}
+func (NullableClientMetric) ConsumerWorkers(_ QueueName, _ int)                                {}
+
+// QueueState is the state of a queue reported by the broker.
+type QueueState struct {
+	Name      QueueName
+	Messages  int
+	Consumers int
+}
+
+// InspectQueue returns the state of the queue without declaring it.
+func (c *Client) InspectQueue(name QueueName) (QueueState, error) {
+	// a dedicated channel is used, since the broker closes the channel if the queue doesn't exist
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return QueueState{}, fmt.Errorf("open channel: %w", err)
+	}
+	defer func() { _ = ch.Close() }()
+
+	q, err := ch.QueueInspect(string(name))
+	if err != nil {
+		return QueueState{}, fmt.Errorf("inspect queue %s: %w", name, err)
+	}
+
+	return QueueState{Name: name, Messages: q.Messages, Consumers: q.Consumers}, nil
+}
+
+// InspectQueuesAsync reports the depth and the consumer count of the queues to ClientMetric every interval.
+func (c *Client) InspectQueuesAsync(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, queues ...QueueName) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.inspectQueues(queues)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *Client) inspectQueues(queues []QueueName) {
+	for _, name := range queues {
+		state, err := c.InspectQueue(name)
+		if err != nil {
+			log.Errorf("Inspect queue: %v", err)
+			continue
+		}
+
+		c.metric.QueueInspected(state)
+	}
+}
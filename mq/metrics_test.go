@@ -0,0 +1,130 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingClientMetric struct {
+	NullableClientMetric
+
+	mu                           sync.Mutex
+	delivered, redelivered       int
+	acked, retried, deadLettered int
+	discarded                    int
+}
+
+func (m *recordingClientMetric) Delivered(_ QueueName, redelivered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delivered++
+	if redelivered {
+		m.redelivered++
+	}
+}
+
+func (m *recordingClientMetric) Acked(_ QueueName, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acked += count
+}
+
+func (m *recordingClientMetric) Retried(_ QueueName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retried++
+}
+
+func (m *recordingClientMetric) Discarded(_ QueueName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.discarded++
+}
+
+func (m *recordingClientMetric) DeadLettered(_ QueueName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadLettered++
+}
+
+func TestConsumer_ClientMetric(t *testing.T) {
+	broker := NewMemoryBroker()
+	metric := &recordingClientMetric{}
+	broker.metric = metric
+
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.Declare())
+
+	options := DefaultConsumerOptions(1)
+	options.RetryDelay = 0
+	options.MaxRetries = 1
+
+	consumer := broker.InitConsumer("deposits", options, MessageProcessorFunc(func(m Message) error {
+		switch string(m) {
+		case "invalid":
+			return ErrPoisonMessage
+		case "failing":
+			return errors.New("failed")
+		}
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+
+	for _, body := range []string{"valid", "invalid", "failing"} {
+		require.NoError(t, queue.Publish([]byte(body)))
+	}
+
+	// valid, failing and its retry are acknowledged
+	require.NoError(t, broker.WaitForAck(ctx, "deposits", 3))
+	require.NoError(t, broker.WaitForNack(ctx, "deposits", 1))
+
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+	assert.Equal(t, 4, metric.delivered)
+	assert.Equal(t, 1, metric.acked)
+	assert.Equal(t, 1, metric.retried)
+	// the retry of the failing message has no retries left
+	assert.Equal(t, 1, metric.discarded)
+	assert.Equal(t, 1, metric.deadLettered)
+}
+
+func TestPublishedQueueLabel(t *testing.T) {
+	assert.Equal(t, "", publishedQueueLabel("deposits", "btc.transfer"))
+	assert.Equal(t, "deposits", publishedQueueLabel("", "deposits"))
+	assert.Equal(t, "amq.gen-", publishedQueueLabel("", "amq.gen-JzTY20BRgKO-HjmUJj0wLg"))
+}
+
+func TestNewClientMetric(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metric := NewClientMetric("test", prometheus.Labels{"service": "mq"}, reg)
+
+	metric.Published("deposits", "btc", time.Millisecond, nil)
+	metric.Published("deposits", "btc", time.Millisecond, errors.New("closed"))
+	metric.Nacked("deposits", true)
+	metric.QueueInspected(QueueState{Name: "deposits", Messages: 3, Consumers: 1})
+	metric.ConsumerWorkers("deposits", 4)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	assert.True(t, names["test_mq_published_total"])
+	assert.True(t, names["test_mq_publish_duration_seconds"])
+	assert.True(t, names["test_mq_nacked_total"])
+	assert.True(t, names["test_mq_queue_messages"])
+	assert.True(t, names["test_mq_queue_consumers"])
+	assert.True(t, names["test_mq_consumer_workers"])
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -18,21 +19,36 @@ type (
 	Message      []byte
 )
 
-const (
-	reconnectionAttemptsNum = 5
-	reconnectionTimeout     = time.Second * 30
-)
-
 type Client struct {
-	url  string
-	conn *amqp.Connection
+	url string
+
+	// connMu guards conn and amqpChan, which are replaced on reconnect, use connection and managementChannel
+	connMu sync.RWMutex
+	conn   *amqp.Connection
 
 	// This channel should only be used for management related operations, like declaring queues & exchanges
 	amqpChan *amqp.Channel
 
+	// publishPool holds the channels used for publishing, so that messages can be published concurrently
+	publishPool       *channelPool
+	confirmPool       *channelPool
+	publishPoolSize   int
+	channelPoolMetric ChannelPoolMetric
+
+	metric ClientMetric
+
 	connClients []ConnectionClient
+	topologies  []Topology
 
 	connCheckTimeout time.Duration
+
+	reconnectPolicy         ReconnectPolicy
+	publishReconnectTimeout time.Duration
+
+	stateMu       sync.Mutex
+	state         ConnectionState
+	stateChanged  chan struct{}
+	connListeners []ConnectionListener
 }
 
 type Option func(c *Client) error
@@ -53,7 +69,12 @@ func Connect(url string, options ...Option) (*Client, error) {
 		conn:     conn,
 		amqpChan: amqpChan,
 
-		connCheckTimeout: time.Second * 10, // default value
+		connCheckTimeout:  time.Second * 10, // default value
+		publishPoolSize:   defaultPublishChannelPoolSize,
+		channelPoolMetric: &NullableChannelPoolMetric{},
+		metric:            &NullableClientMetric{},
+		reconnectPolicy:   DefaultReconnectPolicy(),
+		state:             ConnectionStateConnected,
 	}
 
 	for _, opt := range options {
@@ -63,12 +84,20 @@ func Connect(url string, options ...Option) (*Client, error) {
 		}
 	}
 
+	c.publishPool = newChannelPool(publishChannelPool, connChannelOpener(conn), c.publishPoolSize, c.channelPoolMetric)
+	c.confirmPool = newChannelPool(confirmChannelPool, connChannelOpener(conn), c.publishPoolSize, c.channelPoolMetric)
+	c.confirmPool.confirm = true
+
 	return c, nil
 }
 
 func (c *Client) Close() error {
-	if c.conn != nil && !c.conn.IsClosed() {
-		err := c.conn.Close()
+	if c.State() != ConnectionStateClosed {
+		c.setState(ConnectionEvent{State: ConnectionStateClosed})
+	}
+
+	if conn := c.connection(); conn != nil && !conn.IsClosed() {
+		err := conn.Close()
 		if err != nil {
 			return fmt.Errorf("close connection: %v", err)
 		}
@@ -92,12 +121,12 @@ func (c *Client) InitExchange(name ExchangeName) Exchange {
 }
 
 func (c *Client) InitConsumer(queueName QueueName, options *ConsumerOptions, processor MessageProcessor) Consumer {
-	return &consumer{
-		client:           c,
-		queue:            c.InitQueue(queueName),
-		messageProcessor: processor,
-		options:          options,
-	}
+	return c.InitDeliveryConsumer(queueName, options, messageProcessorAdapter{processor: processor})
+}
+
+// InitDeliveryConsumer inits a consumer whose processor receives the message metadata along with the body.
+func (c *Client) InitDeliveryConsumer(queueName QueueName, options *ConsumerOptions, processor DeliveryProcessor) Consumer {
+	return newConsumer(c, c.InitQueue(queueName), options, processor)
 }
 
 func (c *Client) StartConsumers(ctx context.Context, consumers ...Consumer) error {
@@ -117,20 +146,23 @@ func (c *Client) AddConnectionClient(connClient ConnectionClient) {
 	c.connClients = append(c.connClients, connClient)
 }
 
+// ListenConnectionAsync runs ListenConnection in background.
+// If reconnection fails, the error is logged and the client moves to ConnectionStateFailed,
+// use OptionConnectionListener to react on it.
 func (c *Client) ListenConnectionAsync(ctx context.Context, wg *sync.WaitGroup) {
 	wg.Add(1)
 	go func() {
-		err := c.ListenConnection(ctx)
-		if err != nil {
-			log.Fatal(err)
+		defer wg.Done()
+
+		if err := c.ListenConnection(ctx); err != nil {
+			log.Errorf("Listen MQ connection: %v", err)
 		}
-		wg.Done()
 	}()
 }
 
 func (c *Client) initNotifyCloseListeners() (<-chan *amqp.Error, <-chan *amqp.Error) {
-	return c.conn.NotifyClose(make(chan *amqp.Error)),
-		c.amqpChan.NotifyClose(make(chan *amqp.Error))
+	return c.connection().NotifyClose(make(chan *amqp.Error)),
+		c.managementChannel().NotifyClose(make(chan *amqp.Error))
 }
 
 func (c *Client) ListenConnection(ctx context.Context) error {
@@ -159,13 +191,14 @@ func (c *Client) ListenConnection(ctx context.Context) error {
 				log.Errorf("amqp channel closed with error: %v", err)
 			}
 
-			if c.conn.IsClosed() {
+			conn := c.connection()
+			if conn.IsClosed() {
 				break
 			}
 
 			// close connection to trigger reconnect logic
 			// it will send notification to connErrCh
-			if err := c.conn.Close(); err != nil {
+			if err := conn.Close(); err != nil {
 				return fmt.Errorf("close connection: %v", err)
 			}
 
@@ -175,8 +208,13 @@ func (c *Client) ListenConnection(ctx context.Context) error {
 				log.Errorf("connection closed with error: %v", err)
 			}
 
-			if err := c.reconnectWithRetry(ctx); err != nil {
-				return fmt.Errorf("check mq connection: %v", err)
+			var cause error
+			if err != nil {
+				cause = err
+			}
+
+			if err := c.reconnectWithRetry(ctx, cause); err != nil {
+				return fmt.Errorf("check mq connection: %w", err)
 			}
 
 			// reassign listeners to new connection and channel
@@ -185,15 +223,38 @@ func (c *Client) ListenConnection(ctx context.Context) error {
 	}
 }
 
-func (c *Client) reconnectWithRetry(ctx context.Context) error {
-	for i := 0; i < reconnectionAttemptsNum; i++ {
-		time.Sleep(reconnectionTimeout)
+func (c *Client) reconnectWithRetry(ctx context.Context, cause error) error {
+	policy := c.reconnectPolicy
+	lastErr := cause
+
+	for attempt := 0; policy.hasAttempt(attempt); attempt++ {
+		c.setState(ConnectionEvent{State: ConnectionStateReconnecting, Attempt: attempt + 1, Err: lastErr})
 
-		log.Info("Connecting to MQ... Attempt ", i+1)
+		select {
+		case <-ctx.Done():
+			c.setState(ConnectionEvent{State: ConnectionStateClosed, Err: ctx.Err()})
+			return ctx.Err()
+		case <-time.After(policy.delay(attempt)):
+		}
+
+		log.Info("Connecting to MQ... Attempt ", attempt+1)
 
 		err := c.reconnect()
+		c.metric.ReconnectionAttempt(err)
 		if err != nil {
 			log.Errorf("Reconnect: %v", err)
+			lastErr = err
+			continue
+		}
+
+		// queues have to exist before consumers are restarted
+		if err := c.reapplyTopologies(); err != nil {
+			log.Errorf("Apply topology: %v", err)
+			lastErr = err
+			// the next attempt opens a new connection
+			if err := c.connection().Close(); err != nil {
+				log.Errorf("Close connection: %v", err)
+			}
 			continue
 		}
 
@@ -205,12 +266,15 @@ func (c *Client) reconnectWithRetry(ctx context.Context) error {
 			}
 		}
 
+		c.setState(ConnectionEvent{State: ConnectionStateConnected, Attempt: attempt + 1})
 		log.Info("MQ connection established")
 		return nil
 	}
 
-	return fmt.Errorf("failed to establish MQ connection")
+	err := fmt.Errorf("failed to establish MQ connection: %v", lastErr)
+	c.setState(ConnectionEvent{State: ConnectionStateFailed, Err: err})
 
+	return err
 }
 
 func (c *Client) reconnect() error {
@@ -220,21 +284,71 @@ func (c *Client) reconnect() error {
 	}
 	amqpChan, err := conn.Channel()
 	if err != nil {
+		_ = conn.Close()
 		return err
 	}
 
+	c.connMu.Lock()
 	c.conn = conn
 	c.amqpChan = amqpChan
+	c.connMu.Unlock()
+
+	c.publishPool.reset(connChannelOpener(conn))
+	c.confirmPool.reset(connChannelOpener(conn))
 
 	return nil
 }
 
-func publish(amqpChan *amqp.Channel, exchange ExchangeName, key ExchangeKey, body []byte) error {
-	return publishWithConfig(amqpChan, exchange, key, body, PublishConfig{})
+func (c *Client) publish(exchange ExchangeName, key ExchangeKey, body []byte) error {
+	return c.publishWithConfig(context.Background(), exchange, key, body, PublishConfig{})
+}
+
+// publishWithConfig injects the trace context and the request ID of the context into the message headers
+func (c *Client) publishWithConfig(ctx context.Context, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error {
+	if err := c.waitConnected(ctx); err != nil {
+		return err
+	}
+
+	cfg.Headers = InjectContext(ctx, cfg.Headers)
+
+	if cfg.Mandatory {
+		// returns of unroutable messages can only be matched to the publish with confirmations
+		return c.PublishWithConfirm(ctx, exchange, key, body, cfg)
+	}
+
+	start := time.Now()
+	err := c.publishPool.do(func(ch amqpChannel) error {
+		return ch.Publish(string(exchange), string(key), false, false, newPublishing(body, cfg))
+	})
+	c.metric.Published(exchange, key, time.Since(start), err)
+
+	return err
+}
+
+// PublishWithConfirm publishes the message and waits until the broker confirms it,
+// so the message is guaranteed to be persisted if it's routed to durable queues.
+// ErrPublishNotConfirmed is returned if the broker rejects the message.
+func (c *Client) PublishWithConfirm(ctx context.Context, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error {
+	if err := c.waitConnected(ctx); err != nil {
+		return err
+	}
+
+	cfg.Headers = InjectContext(ctx, cfg.Headers)
+
+	start := time.Now()
+	err := c.confirmPool.doConfirmed(ctx, func(ch amqpChannel) error {
+		return ch.Publish(string(exchange), string(key), cfg.Mandatory, false, newPublishing(body, cfg))
+	})
+	c.metric.Published(exchange, key, time.Since(start), err)
+
+	return err
 }
 
-func publishWithConfig(amqpChan *amqp.Channel, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error {
-	headers := map[string]interface{}{}
+func newPublishing(body []byte, cfg PublishConfig) amqp.Publishing {
+	headers := make(map[string]interface{}, len(cfg.Headers)+1)
+	for k, v := range cfg.Headers {
+		headers[k] = v
+	}
 
 	if cfg.MaxRetries != nil {
 		headers[headerRemainingRetries] = *cfg.MaxRetries
@@ -247,12 +361,89 @@ func publishWithConfig(amqpChan *amqp.Channel, exchange ExchangeName, key Exchan
 		deliveryMode = amqp.Persistent
 	}
 
-	return amqpChan.Publish(string(exchange), string(key), false, false, amqp.Publishing{
-		DeliveryMode: deliveryMode,
-		ContentType:  "text/plain",
-		Body:         body,
-		Headers:      headers,
-	})
+	contentType := cfg.ContentType
+	if contentType == "" {
+		contentType = ContentTypeText
+	}
+
+	var expiration string
+	if cfg.Expiration > 0 {
+		expiration = strconv.FormatInt(cfg.Expiration.Milliseconds(), 10)
+	}
+
+	return amqp.Publishing{
+		DeliveryMode:    deliveryMode,
+		ContentType:     contentType,
+		ContentEncoding: cfg.ContentEncoding,
+		Priority:        cfg.Priority,
+		Expiration:      expiration,
+		AppId:           cfg.AppID,
+		MessageId:       cfg.MessageID,
+		CorrelationId:   cfg.CorrelationID,
+		ReplyTo:         cfg.ReplyTo,
+		Timestamp:       cfg.Timestamp,
+		Body:            body,
+		Headers:         headers,
+	}
+}
+
+// consume opens a dedicated channel for a consumer of the queue
+func (c *Client) consume(ctx context.Context, stop <-chan struct{}, queue QueueName, prefetch int) (<-chan amqp.Delivery, error) {
+	messageChannel, _, err := c.subscribe(ctx, stop, queue, prefetch)
+	return messageChannel, err
+}
+
+// subscribe is consume which also returns a function to change the prefetch count of the consumer's channel
+// The channel isn't closed on stop, its consumers stop reading it and it's closed along with the connection.
+func (c *Client) subscribe(_ context.Context, _ <-chan struct{}, queue QueueName, prefetch int) (<-chan amqp.Delivery, qosFunc, error) {
+	mqChan, err := c.connection().Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("MQ issue. queue: %s, err: %w", string(queue), err)
+	}
+
+	setPrefetch := func(prefetch int) error {
+		return mqChan.Qos(prefetch, 0, true)
+	}
+
+	err = setPrefetch(prefetch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("MQ issue. queue: %s, err: %w", string(queue), err)
+	}
+
+	messageChannel, err := mqChan.Consume(
+		string(queue),
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("MQ issue" + err.Error() + " for queue: " + string(queue))
+	}
+
+	return messageChannel, setPrefetch, nil
+}
+
+// connection returns the current connection, it's replaced on reconnect
+func (c *Client) connection() *amqp.Connection {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.conn
+}
+
+// managementChannel returns the channel of the current connection used for declarations
+func (c *Client) managementChannel() *amqp.Channel {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.amqpChan
+}
+
+func (c *Client) clientMetric() ClientMetric {
+	return c.metric
 }
 
 type ConnectionClient interface {
@@ -260,7 +451,7 @@ type ConnectionClient interface {
 }
 
 func (c *Client) HealthCheck() error {
-	if c.conn.IsClosed() {
+	if c.connection().IsClosed() {
 		return errors.New("connection is closed")
 	}
 
@@ -1,6 +1,8 @@
 package mq
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/trustwallet/go-libs/metrics"
@@ -16,6 +18,44 @@ type ConsumerOptions struct {
 	// MaxRetries specifies the default number of retries for consuming a message.
 	// A negative value is equal to infinite retries.
 	MaxRetries int
+
+	// ProcessingTimeout limits the processing time of a single message, zero means no limit.
+	// The processor's context is cancelled after the timeout, and once the processor returns an error,
+	// the message is handled as failed with ErrProcessingTimeout. The worker waits for the processor to return,
+	// so a processor ignoring the context blocks its worker. Side effects made before the cancellation
+	// are repeated when the message is retried, so a timed out message may be processed twice.
+	ProcessingTimeout time.Duration
+
+	// Deduplication makes the consumer skip messages which have already been processed, nil disables it.
+	// It's not supported by batch consumers.
+	Deduplication *DeduplicationOptions
+
+	// PartitionKey enables the ordering mode, messages with the same key are processed by the same worker
+	// in the order of delivery. Failed messages are retried in place, blocking their key meanwhile,
+	// so MaxRetries must not be negative. Messages requeued with ErrRequeue lose their order.
+	// It's not supported by batch consumers.
+	PartitionKey PartitionKeyFunc
+
+	// Autoscaling makes the number of workers follow the backlog of the queue, Workers and Prefetch are ignored then.
+	// It can't be combined with PartitionKey and it's not supported by batch consumers.
+	Autoscaling *AutoscalingOptions
+}
+
+func (o *ConsumerOptions) validate() error {
+	if o.PartitionKey != nil {
+		if o.Workers < 1 {
+			return fmt.Errorf("ordering mode requires at least 1 worker, got %d", o.Workers)
+		}
+		if o.MaxRetries < 0 {
+			return errors.New("ordering mode requires limited MaxRetries, since failed messages block their key")
+		}
+	}
+	if o.Deduplication != nil && o.ProcessingTimeout > 0 && o.Deduplication.processingTTL() <= o.ProcessingTimeout {
+		return fmt.Errorf("deduplication processing ttl %s must exceed processing timeout %s",
+			o.Deduplication.processingTTL(), o.ProcessingTimeout)
+	}
+
+	return nil
 }
 
 func DefaultConsumerOptions(workers int) *ConsumerOptions {
@@ -29,6 +69,36 @@ func DefaultConsumerOptions(workers int) *ConsumerOptions {
 	}
 }
 
+// BatchConsumerOptions configures a batch consumer.
+// Prefetch is ignored, it always equals to BatchSize.
+type BatchConsumerOptions struct {
+	ConsumerOptions
+
+	// BatchSize is the maximum number of messages processed at once.
+	BatchSize int
+	// BatchTimeout is the maximum time to wait for a batch to fill up before processing it.
+	BatchTimeout time.Duration
+}
+
+func DefaultBatchConsumerOptions(workers, batchSize int, batchTimeout time.Duration) *BatchConsumerOptions {
+	return &BatchConsumerOptions{
+		ConsumerOptions: *DefaultConsumerOptions(workers),
+		BatchSize:       batchSize,
+		BatchTimeout:    batchTimeout,
+	}
+}
+
+func (o *BatchConsumerOptions) validate() error {
+	if o.BatchSize < 1 || o.BatchTimeout <= 0 {
+		return fmt.Errorf("batch size %d, batch timeout %s", o.BatchSize, o.BatchTimeout)
+	}
+	if o.Deduplication != nil || o.PartitionKey != nil || o.Autoscaling != nil {
+		return errors.New("deduplication, partition key and autoscaling aren't supported by batch consumers")
+	}
+
+	return o.ConsumerOptions.validate()
+}
+
 // Deprecated: We should not put prefetch limit at channel level. We need to set limit at consumer level
 // This option no longer works to limit QoS globally.
 //
@@ -57,3 +127,59 @@ func OptionConnCheckTimeout(timeout time.Duration) Option {
 		return nil
 	}
 }
+
+// OptionPublishChannelPoolSize sets the number of channels used for concurrent publishing.
+func OptionPublishChannelPoolSize(size int) Option {
+	return func(m *Client) error {
+		if size < 1 {
+			return fmt.Errorf("invalid publish channel pool size: %d", size)
+		}
+		m.publishPoolSize = size
+		return nil
+	}
+}
+
+// OptionChannelPoolMetric sets the metric recording the publishing channel pool usage.
+// See NewChannelPoolMetric.
+func OptionChannelPoolMetric(metric ChannelPoolMetric) Option {
+	return func(m *Client) error {
+		m.channelPoolMetric = metric
+		return nil
+	}
+}
+
+// OptionClientMetric sets the metric of publishing, consuming and reconnection events.
+func OptionClientMetric(metric ClientMetric) Option {
+	return func(c *Client) error {
+		c.metric = metric
+		return nil
+	}
+}
+
+// OptionReconnectPolicy sets the delays and the number of reconnection attempts, see DefaultReconnectPolicy.
+func OptionReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(c *Client) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		c.reconnectPolicy = policy
+		return nil
+	}
+}
+
+// OptionPublishReconnectTimeout makes publishes wait up to the timeout while the client is reconnecting.
+// By default, publishes fail immediately with ErrReconnecting.
+func OptionPublishReconnectTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.publishReconnectTimeout = timeout
+		return nil
+	}
+}
+
+// OptionConnectionListener adds a listener of the connection state changes.
+func OptionConnectionListener(listener ConnectionListener) Option {
+	return func(c *Client) error {
+		c.connListeners = append(c.connListeners, listener)
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const hashRingReplicas = 100
+
+// PartitionKeyFunc extracts the key whose messages have to be processed in order, e.g. an account address.
+type PartitionKeyFunc func(d Delivery) string
+
+// PartitionByHeader uses a string header as the partition key.
+// Messages without the header share the empty key.
+func PartitionByHeader(header string) PartitionKeyFunc {
+	return func(d Delivery) string {
+		key, _ := d.Headers[header].(string)
+		return key
+	}
+}
+
+// PartitionByRoutingKey uses the routing key of the message as the partition key.
+func PartitionByRoutingKey(d Delivery) string {
+	return string(d.RoutingKey)
+}
+
+// hashRing assigns keys to workers by consistent hashing
+type hashRing struct {
+	hashes  []uint32
+	workers map[uint32]int
+}
+
+func newHashRing(workers int) *hashRing {
+	r := &hashRing{
+		hashes:  make([]uint32, 0, workers*hashRingReplicas),
+		workers: make(map[uint32]int, workers*hashRingReplicas),
+	}
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < hashRingReplicas; i++ {
+			h := hashKey(fmt.Sprintf("worker-%d-%d", w, i))
+			if _, ok := r.workers[h]; ok {
+				continue
+			}
+			r.workers[h] = w
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+func (r *hashRing) worker(key string) int {
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.workers[r.hashes[i]]
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// startOrdered dispatches the messages to the workers by the partition key.
+// Every worker has a buffer as large as the prefetch count, so the dispatcher never blocks on a slow worker:
+// the broker stops delivering once the prefetch count of messages is unacknowledged.
+// The messages are acknowledged individually, so a slow worker doesn't hold back the acks of the others.
+func (c *consumer) startOrdered(ctx context.Context, messages <-chan amqp.Delivery, stopChan chan struct{}) {
+	ring := newHashRing(c.options.Workers)
+	prefetch := c.getSanitizedPrefetchCount()
+
+	partitions := make([]chan amqp.Delivery, c.options.Workers)
+	for w := range partitions {
+		partitions[w] = make(chan amqp.Delivery, prefetch)
+		go c.consumeOrdered(ctx, partitions[w], stopChan)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stopChan:
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if msg.Body == nil {
+					continue
+				}
+
+				key := c.options.PartitionKey(newDelivery(msg, 0))
+				partitions[ring.worker(key)] <- msg
+			}
+		}
+	}()
+}
+
+func (c *consumer) consumeOrdered(ctx context.Context, partition <-chan amqp.Delivery, stopChan chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopChan:
+			// buffered messages are redelivered by the broker, since they are not acknowledged
+			return
+		case msg := <-partition:
+			c.broker.clientMetric().Delivered(c.queue.Name(), msg.Redelivered)
+			c.processOrdered(ctx, msg, stopChan)
+		}
+	}
+}
+
+// processOrdered retries the message in place instead of republishing it,
+// since a republished message would be processed after the next messages of its key.
+func (c *consumer) processOrdered(ctx context.Context, msg amqp.Delivery, stopChan <-chan struct{}) {
+	remainingRetries := c.getRemainingRetries(msg)
+	if remainingRetries < 0 {
+		// infinite retries would block the key forever
+		remainingRetries = int32(c.options.MaxRetries)
+	}
+	msgCtx := ExtractContext(ctx, msg.Headers)
+
+	for {
+		err := c.process(msgCtx, newDelivery(msg, remainingRetries))
+		if err != nil {
+			log.WithFields(LogFields(msgCtx)).Error(err)
+		}
+
+		outcome, delay := c.decide(err)
+		if outcome == outcomeRetry && remainingRetries == 0 {
+			outcome = outcomeDiscard
+		}
+
+		if outcome != outcomeRetry {
+			if sleep(ctx, stopChan, delay) {
+				c.settle(msg, outcome)
+			}
+			return
+		}
+
+		// once the consumer is stopped, the message is redelivered by the broker to the new workers
+		if !sleep(ctx, stopChan, delay) || stopped(ctx, stopChan) {
+			return
+		}
+
+		remainingRetries--
+		c.broker.clientMetric().Retried(c.queue.Name())
+	}
+}
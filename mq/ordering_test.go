@@ -0,0 +1,155 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := newHashRing(4)
+
+	counts := make([]int, 4)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("account-%d", i)
+		w := ring.worker(key)
+		assert.Equal(t, w, ring.worker(key), "a key is always assigned to the same worker")
+		counts[w]++
+	}
+
+	for w, count := range counts {
+		assert.Greater(t, count, 1000, "worker %d", w)
+	}
+
+	// growing the ring moves only a part of the keys
+	grown := newHashRing(5)
+	var moved int
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("account-%d", i)
+		if ring.worker(key) != grown.worker(key) {
+			moved++
+		}
+	}
+	assert.Less(t, moved, 4000)
+}
+
+func TestConsumer_Ordering(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := broker.InitQueue("transfers")
+	require.NoError(t, queue.Declare())
+
+	var (
+		mu        sync.Mutex
+		processed = map[string][]int{}
+		failed    = map[string]bool{}
+	)
+
+	options := DefaultConsumerOptions(4)
+	options.Prefetch = 20
+	options.RetryDelay = time.Millisecond
+	options.MaxRetries = 3
+	options.PartitionKey = PartitionByHeader("account")
+
+	consumer := broker.InitDeliveryConsumer("transfers", options, DeliveryProcessorFunc(
+		func(_ context.Context, d Delivery) error {
+			account := d.Headers["account"].(string)
+			var seq int
+			_, _ = fmt.Sscanf(string(d.Body), "%d", &seq)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			// the first message of every account fails once
+			if seq == 0 && !failed[account] {
+				failed[account] = true
+				return errors.New("temporary failure")
+			}
+			processed[account] = append(processed[account], seq)
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+
+	const accounts, transfers = 8, 10
+	for seq := 0; seq < transfers; seq++ {
+		for a := 0; a < accounts; a++ {
+			err := queue.PublishWithConfig([]byte(fmt.Sprint(seq)), PublishConfig{
+				Headers: map[string]interface{}{"account": fmt.Sprintf("account-%d", a)},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, broker.WaitForAck(ctx, "transfers", accounts*transfers))
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	for a := 0; a < accounts; a++ {
+		assert.Equal(t, expected, processed[fmt.Sprintf("account-%d", a)])
+	}
+	// retries don't republish the message
+	assert.Len(t, broker.PublishedMessages("transfers"), accounts*transfers)
+}
+
+func TestConsumer_OrderingValidatesOptions(t *testing.T) {
+	broker := NewMemoryBroker()
+	processor := MessageProcessorFunc(func(Message) error { return nil })
+
+	options := DefaultConsumerOptions(4)
+	options.PartitionKey = PartitionByRoutingKey
+	assert.Error(t, broker.InitConsumer("transfers", options, processor).Start(context.Background()),
+		"infinite in-place retries must be rejected")
+
+	options = DefaultConsumerOptions(0)
+	options.MaxRetries = 3
+	options.PartitionKey = PartitionByRoutingKey
+	assert.Error(t, broker.InitConsumer("transfers", options, processor).Start(context.Background()))
+}
+
+func TestConsumer_ProcessOrderedStopsRetrying(t *testing.T) {
+	options := DefaultConsumerOptions(1)
+	options.RetryDelay = 0
+	options.MaxRetries = 1000
+
+	stopChan := make(chan struct{})
+	var calls int
+	c := &consumer{
+		broker:  NewMemoryBroker(),
+		queue:   &fakeQueue{},
+		options: options,
+		processor: DeliveryProcessorFunc(func(context.Context, Delivery) error {
+			calls++
+			if calls == 2 {
+				close(stopChan)
+			}
+			return errors.New("failed")
+		}),
+	}
+
+	acknowledger := &fakeAcknowledger{}
+	c.processOrdered(context.Background(), amqp.Delivery{Acknowledger: acknowledger, Body: []byte("1")}, stopChan)
+
+	assert.Equal(t, 2, calls, "a stopped worker must not retry the message")
+	assert.Empty(t, acknowledger.getCalls(), "the message must be left for the new workers")
+
+	// a message without retries left is discarded
+	calls = 0
+	options.MaxRetries = 1
+	c.processor = DeliveryProcessorFunc(func(context.Context, Delivery) error {
+		calls++
+		return errors.New("failed")
+	})
+	c.processOrdered(context.Background(), amqp.Delivery{Acknowledger: acknowledger, Body: []byte("1")}, nil)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []ackCall{{ack: true}}, acknowledger.getCalls())
+}
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS mq_outbox
+(
+    id             BIGSERIAL PRIMARY KEY,
+    exchange       TEXT        NOT NULL,
+    routing_key    TEXT        NOT NULL,
+    body           BYTEA       NOT NULL,
+    content_type   TEXT        NOT NULL DEFAULT '',
+    message_id     TEXT        NOT NULL DEFAULT '',
+    correlation_id TEXT        NOT NULL DEFAULT '',
+    delivery_mode  SMALLINT    NOT NULL DEFAULT 0,
+    max_retries    INTEGER,
+    headers        JSONB,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at        TIMESTAMPTZ,
+    attempts       INTEGER     NOT NULL DEFAULT 0,
+    last_error     TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS mq_outbox_unsent_idx ON mq_outbox (id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS mq_outbox_sent_at_idx ON mq_outbox (sent_at) WHERE sent_at IS NOT NULL;
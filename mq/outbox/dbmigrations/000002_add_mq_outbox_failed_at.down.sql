@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS mq_outbox_unsent_idx;
+CREATE INDEX IF NOT EXISTS mq_outbox_unsent_idx ON mq_outbox (id) WHERE sent_at IS NULL;
+
+ALTER TABLE mq_outbox DROP COLUMN IF EXISTS failed_at;
@@ -0,0 +1,4 @@
+ALTER TABLE mq_outbox ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS mq_outbox_unsent_idx;
+CREATE INDEX IF NOT EXISTS mq_outbox_unsent_idx ON mq_outbox (id) WHERE sent_at IS NULL AND failed_at IS NULL;
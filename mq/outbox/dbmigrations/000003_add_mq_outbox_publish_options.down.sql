@@ -0,0 +1,7 @@
+ALTER TABLE mq_outbox
+    DROP COLUMN IF EXISTS content_encoding,
+    DROP COLUMN IF EXISTS app_id,
+    DROP COLUMN IF EXISTS reply_to,
+    DROP COLUMN IF EXISTS priority,
+    DROP COLUMN IF EXISTS expiration,
+    DROP COLUMN IF EXISTS mandatory;
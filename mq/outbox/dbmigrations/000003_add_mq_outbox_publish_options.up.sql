@@ -0,0 +1,7 @@
+ALTER TABLE mq_outbox
+    ADD COLUMN IF NOT EXISTS content_encoding TEXT     NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS app_id           TEXT     NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS reply_to         TEXT     NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS priority         SMALLINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS expiration       BIGINT   NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS mandatory        BOOLEAN  NOT NULL DEFAULT FALSE;
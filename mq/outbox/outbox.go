@@ -0,0 +1,151 @@
+// Package outbox implements the transactional outbox pattern on top of database and mq.
+//
+// Messages are written into the outbox table within the database transaction of the business change,
+// and the relay worker publishes them to RabbitMQ afterwards, so a message is published
+// if and only if the transaction is committed.
+// Messages are delivered at least once, consumers have to be idempotent.
+//
+// The table is created by the migrations in the dbmigrations directory,
+// which have to be copied into the migrations of the service.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/trustwallet/go-libs/database"
+	"github.com/trustwallet/go-libs/mq"
+)
+
+const defaultTable = "mq_outbox"
+
+// DB is implemented by *database.DBGetter.
+type DB interface {
+	database.DBContextGetter
+	database.TrxContextGetter
+}
+
+// Message is a row of the outbox table.
+type Message struct {
+	ID              int64
+	Exchange        string
+	RoutingKey      string
+	Body            []byte
+	ContentType     string
+	ContentEncoding string
+	AppID           string
+	MessageID       string
+	CorrelationID   string
+	ReplyTo         string
+	DeliveryMode    uint8
+	Priority        uint8
+	Expiration      time.Duration // stored in nanoseconds
+	Mandatory       bool
+	MaxRetries      *int
+	Headers         *string `gorm:"type:jsonb"`
+	CreatedAt       time.Time
+	SentAt          *time.Time
+	FailedAt        *time.Time
+	Attempts        int
+	LastError       string
+}
+
+func (m Message) publishConfig() (mq.PublishConfig, error) {
+	cfg := mq.PublishConfig{
+		MaxRetries:      m.MaxRetries,
+		DeliveryMode:    mq.DeliveryMode(m.DeliveryMode),
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		AppID:           m.AppID,
+		Priority:        m.Priority,
+		Expiration:      m.Expiration,
+		Mandatory:       m.Mandatory,
+		MessageID:       m.MessageID,
+		CorrelationID:   m.CorrelationID,
+		ReplyTo:         m.ReplyTo,
+		Timestamp:       m.CreatedAt,
+	}
+
+	if m.Headers != nil {
+		if err := json.Unmarshal([]byte(*m.Headers), &cfg.Headers); err != nil {
+			return mq.PublishConfig{}, fmt.Errorf("unmarshal headers of message %d: %w", m.ID, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+type Option func(o *options)
+
+type options struct {
+	table string
+}
+
+// WithTable sets the name of the outbox table, mq_outbox is used by default.
+func WithTable(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{table: defaultTable}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Outbox writes outgoing messages into the outbox table.
+type Outbox struct {
+	db    database.DBContextGetter
+	table string
+}
+
+func New(db database.DBContextGetter, opts ...Option) *Outbox {
+	return &Outbox{
+		db:    db,
+		table: newOptions(opts).table,
+	}
+}
+
+// Publish writes the message into the outbox table using the transaction of the context, see database.DBGetter.Transaction.
+// Header values are stored as JSON, so numbers are published as float64.
+// The trace context and the request ID of the context are stored to be propagated by the relay.
+func (o *Outbox) Publish(ctx context.Context, exchange mq.ExchangeName, key mq.ExchangeKey, body []byte, cfg mq.PublishConfig) error {
+	cfg.Headers = mq.InjectContext(ctx, cfg.Headers)
+	msg := Message{
+		Exchange:        string(exchange),
+		RoutingKey:      string(key),
+		Body:            body,
+		ContentType:     cfg.ContentType,
+		ContentEncoding: cfg.ContentEncoding,
+		AppID:           cfg.AppID,
+		MessageID:       cfg.MessageID,
+		CorrelationID:   cfg.CorrelationID,
+		ReplyTo:         cfg.ReplyTo,
+		DeliveryMode:    uint8(cfg.DeliveryMode),
+		Priority:        cfg.Priority,
+		Expiration:      cfg.Expiration,
+		Mandatory:       cfg.Mandatory,
+		MaxRetries:      cfg.MaxRetries,
+	}
+
+	if len(cfg.Headers) > 0 {
+		headers, err := json.Marshal(cfg.Headers)
+		if err != nil {
+			return fmt.Errorf("marshal headers: %w", err)
+		}
+		h := string(headers)
+		msg.Headers = &h
+	}
+
+	if err := o.db.DBFrom(ctx).WithContext(ctx).Table(o.table).Create(&msg).Error; err != nil {
+		return fmt.Errorf("insert outbox message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package outbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/trustwallet/go-libs/database"
+	"github.com/trustwallet/go-libs/mq"
+	"github.com/trustwallet/go-libs/testy"
+)
+
+func TestMessage_PublishConfig(t *testing.T) {
+	headers := `{"x-source":"api"}`
+	maxRetries := 3
+
+	cfg, err := Message{
+		ContentType:     mq.ContentTypeJSON,
+		ContentEncoding: "gzip",
+		AppID:           "api",
+		MessageID:       "tx1",
+		ReplyTo:         "replies",
+		DeliveryMode:    uint8(mq.DeliveryModePersistent),
+		Priority:        5,
+		Expiration:      time.Minute,
+		Mandatory:       true,
+		MaxRetries:      &maxRetries,
+		Headers:         &headers,
+	}.publishConfig()
+	require.NoError(t, err)
+
+	assert.Equal(t, mq.ContentTypeJSON, cfg.ContentType)
+	assert.Equal(t, "gzip", cfg.ContentEncoding)
+	assert.Equal(t, "api", cfg.AppID)
+	assert.Equal(t, "tx1", cfg.MessageID)
+	assert.Equal(t, "replies", cfg.ReplyTo)
+	assert.Equal(t, mq.DeliveryModePersistent, cfg.DeliveryMode)
+	assert.Equal(t, uint8(5), cfg.Priority)
+	assert.Equal(t, time.Minute, cfg.Expiration)
+	assert.True(t, cfg.Mandatory)
+	assert.Equal(t, &maxRetries, cfg.MaxRetries)
+	assert.Equal(t, map[string]interface{}{"x-source": "api"}, cfg.Headers)
+
+	invalid := "{"
+	_, err = Message{Headers: &invalid}.publishConfig()
+	assert.Error(t, err)
+}
+
+// newTestDB applies the migrations of the outbox table
+func newTestDB(t *testing.T) (*gorm.DB, *database.DBGetter) {
+	testy.RequireTestTag(t, testy.TagPostgres)
+
+	gormDB, err := testy.NewIntegrationTestDb()
+	require.NoError(t, err)
+
+	migrations, err := filepath.Glob("dbmigrations/*.up.sql")
+	require.NoError(t, err)
+	for _, path := range migrations {
+		migration, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, gormDB.Exec(string(migration)).Error)
+	}
+	t.Cleanup(func() { gormDB.Exec("DROP TABLE mq_outbox") })
+
+	return gormDB, database.NewDBGetterFromGormInstance(gormDB)
+}
+
+func TestRelay(t *testing.T) {
+	gormDB, db := newTestDB(t)
+	ctx := context.Background()
+
+	broker := mq.NewMemoryBroker()
+	require.NoError(t, broker.InitQueue("deposits").Declare())
+
+	outbox := New(db)
+	err := db.Transaction(ctx, func(ctx context.Context) error {
+		for _, body := range []string{"1", "2", "3"} {
+			if err := outbox.Publish(ctx, "", "deposits", []byte(body), mq.PublishConfig{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	options := DefaultRelayOptions()
+	options.BatchSize = 2
+	relay := NewRelay(db, broker, options)
+	require.NoError(t, relay.Relay(ctx))
+
+	assert.Equal(t, []mq.Message{mq.Message("1"), mq.Message("2"), mq.Message("3")}, broker.PublishedMessages("deposits"))
+
+	var unsent int64
+	require.NoError(t, gormDB.Table(defaultTable).Where("sent_at IS NULL").Count(&unsent).Error)
+	assert.Zero(t, unsent)
+
+	options.Retention = 0
+	require.NoError(t, relay.Cleanup(ctx))
+
+	var total int64
+	require.NoError(t, gormDB.Table(defaultTable).Count(&total).Error)
+	assert.Zero(t, total)
+}
+
+func TestRelay_SkipsFailedMessages(t *testing.T) {
+	gormDB, db := newTestDB(t)
+	ctx := context.Background()
+
+	broker := mq.NewMemoryBroker()
+	require.NoError(t, broker.InitQueue("deposits").Declare())
+
+	outbox := New(db)
+	require.NoError(t, outbox.Publish(ctx, "missing", "deposits", []byte("unroutable"), mq.PublishConfig{}))
+	require.NoError(t, outbox.Publish(ctx, "", "deposits", []byte("invalid"), mq.PublishConfig{}))
+	require.NoError(t, gormDB.Table(defaultTable).Where("body = ?", []byte("invalid")).Update("headers", "[]").Error)
+	require.NoError(t, outbox.Publish(ctx, "", "deposits", []byte("valid"), mq.PublishConfig{}))
+
+	options := DefaultRelayOptions()
+	options.MaxAttempts = 2
+	relay := NewRelay(db, broker, options)
+
+	require.NoError(t, relay.Relay(ctx))
+	assert.Empty(t, broker.PublishedMessages("deposits"), "the failed message must stop the batch")
+
+	require.NoError(t, relay.Relay(ctx))
+	assert.Equal(t, []mq.Message{mq.Message("valid")}, broker.PublishedMessages("deposits"))
+
+	var failed []Message
+	require.NoError(t, gormDB.Table(defaultTable).Where("failed_at IS NOT NULL").Order("id").Find(&failed).Error)
+	require.Len(t, failed, 2)
+	assert.Equal(t, 2, failed[0].Attempts)
+	assert.Equal(t, 1, failed[1].Attempts, "messages which cannot be decoded must fail immediately")
+}
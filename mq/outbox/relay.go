@@ -0,0 +1,206 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/trustwallet/go-libs/mq"
+	"github.com/trustwallet/go-libs/worker"
+)
+
+// Publisher publishes messages with broker confirmation, it's implemented by *mq.Client.
+type Publisher interface {
+	PublishWithConfirm(ctx context.Context, exchange mq.ExchangeName, key mq.ExchangeKey, body []byte, cfg mq.PublishConfig) error
+}
+
+type RelayOptions struct {
+	// BatchSize is the maximum number of messages locked and published within a single transaction.
+	BatchSize int
+	// BatchTimeout limits the time the rows of a batch are locked, zero means no limit.
+	// Messages which aren't published in time are left to the next batch.
+	BatchTimeout time.Duration
+	// PublishTimeout limits the wait for the confirmation of a single message.
+	PublishTimeout time.Duration
+	// MaxAttempts is the number of failed publishes after which a message is marked as failed
+	// and skipped, zero means unlimited attempts. Messages which cannot be decoded fail immediately.
+	MaxAttempts int
+	// Retention is the time sent messages are kept in the table before they are deleted.
+	// Failed messages are kept for inspection.
+	Retention time.Duration
+}
+
+func DefaultRelayOptions() *RelayOptions {
+	return &RelayOptions{
+		BatchSize:      100,
+		BatchTimeout:   30 * time.Second,
+		PublishTimeout: 10 * time.Second,
+		MaxAttempts:    10,
+		Retention:      24 * time.Hour,
+	}
+}
+
+// Relay publishes the messages of the outbox table.
+// Rows are locked with FOR UPDATE SKIP LOCKED, so multiple instances of the service can run the relay.
+// A single relay publishes the messages in the order they were written: a message which fails to be published
+// stops the batch and it's retried on the next run, until it runs out of RelayOptions.MaxAttempts
+// and is skipped. Concurrent relays publish their batches in parallel,
+// so the order isn't kept across them, run a single relay if consumers depend on the order.
+type Relay struct {
+	db        DB
+	publisher Publisher
+	table     string
+	options   *RelayOptions
+}
+
+func NewRelay(db DB, publisher Publisher, options *RelayOptions, opts ...Option) *Relay {
+	return &Relay{
+		db:        db,
+		publisher: publisher,
+		table:     newOptions(opts).table,
+		options:   options,
+	}
+}
+
+// NewRelayWorker runs the relay and the cleanup of sent messages every interval of the worker options.
+func NewRelayWorker(relay *Relay, options *worker.WorkerOptions) worker.Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return worker.NewWorkerBuilder("mq_outbox_relay", func() error {
+		if err := relay.Relay(ctx); err != nil {
+			return err
+		}
+		return relay.Cleanup(ctx)
+	}).
+		WithOptions(options).
+		WithStop(func() error {
+			cancel()
+			return nil
+		}).
+		Build()
+}
+
+// Relay publishes all pending messages.
+func (r *Relay) Relay(ctx context.Context) error {
+	for {
+		more, err := r.relayBatch(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
+}
+
+// relayBatch returns false if there are no more messages or a message failed to be published,
+// so the relay waits for the next run.
+func (r *Relay) relayBatch(ctx context.Context) (bool, error) {
+	var more bool
+
+	err := r.db.Transaction(ctx, func(ctx context.Context) error {
+		db := r.db.DBFrom(ctx).WithContext(ctx)
+
+		var messages []Message
+		err := db.Table(r.table).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND failed_at IS NULL").
+			Order("id").
+			Limit(r.options.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return fmt.Errorf("select outbox messages: %w", err)
+		}
+		more = len(messages) == r.options.BatchSize
+
+		deadline := time.Now().Add(r.options.BatchTimeout)
+		sent := make([]int64, 0, len(messages))
+		for _, msg := range messages {
+			if r.options.BatchTimeout > 0 && time.Now().After(deadline) {
+				// the rows are locked during the whole batch, the rest is published by the next one
+				more = true
+				break
+			}
+
+			cfg, err := msg.publishConfig()
+			if err != nil {
+				log.Errorf("Decode outbox message %d: %v", msg.ID, err)
+				if err := r.fail(db, msg, err, true); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := r.publish(ctx, msg, cfg); err != nil {
+				log.Errorf("Publish outbox message %d: %v", msg.ID, err)
+
+				failed := r.options.MaxAttempts > 0 && msg.Attempts+1 >= r.options.MaxAttempts
+				if err := r.fail(db, msg, err, failed); err != nil {
+					return err
+				}
+				if failed {
+					continue
+				}
+
+				more = false
+				break
+			}
+
+			sent = append(sent, msg.ID)
+		}
+
+		if len(sent) > 0 {
+			err := db.Table(r.table).Where("id IN ?", sent).Update("sent_at", time.Now()).Error
+			if err != nil {
+				// the messages are published again, which is fine for at least once delivery
+				return fmt.Errorf("mark outbox messages as sent: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	return more, err
+}
+
+// fail records the failed attempt, failed messages are marked so they're skipped by the next batches
+func (r *Relay) fail(db *gorm.DB, msg Message, cause error, failed bool) error {
+	updates := map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": cause.Error(),
+	}
+	if failed {
+		log.Errorf("Skip failed outbox message %d after %d attempts", msg.ID, msg.Attempts+1)
+		updates["failed_at"] = time.Now()
+	}
+
+	if err := db.Table(r.table).Where("id = ?", msg.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("update failed outbox message: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Relay) publish(ctx context.Context, msg Message, cfg mq.PublishConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, r.options.PublishTimeout)
+	defer cancel()
+
+	return r.publisher.PublishWithConfirm(ctx, mq.ExchangeName(msg.Exchange), mq.ExchangeKey(msg.RoutingKey), msg.Body, cfg)
+}
+
+// Cleanup deletes messages sent earlier than the retention period.
+func (r *Relay) Cleanup(ctx context.Context) error {
+	err := r.db.DBFrom(ctx).WithContext(ctx).Table(r.table).
+		Where("sent_at < ?", time.Now().Add(-r.options.Retention)).
+		Delete(&Message{}).Error
+	if err != nil {
+		return fmt.Errorf("delete sent outbox messages: %w", err)
+	}
+
+	return nil
+}
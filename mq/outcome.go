@@ -0,0 +1,155 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Errors returned by processors to control how the message is settled.
+// They can be wrapped, e.g. fmt.Errorf("%w: unknown asset", mq.ErrDiscard).
+// Any other error is retried according to ConsumerOptions.
+var (
+	// ErrDiscard acknowledges the message without retrying it, e.g. because it's outdated.
+	ErrDiscard = errors.New("discard message")
+	// ErrRequeue returns the message to the queue immediately without spending a retry.
+	ErrRequeue = errors.New("requeue message")
+	// ErrDeadLetter rejects the message without requeue, so the broker routes it to the dead letter exchange of the queue.
+	// It's dropped if the queue has no dead letter exchange.
+	ErrDeadLetter = errors.New("dead letter message")
+)
+
+// RetryAfterError retries the message after the delay instead of ConsumerOptions.RetryDelay.
+// The message is retried even if ConsumerOptions.RetryOnError is disabled, spending a retry.
+type RetryAfterError struct {
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("retry message after %s", e.Delay)
+}
+
+// RetryAfter returns RetryAfterError with the delay.
+func RetryAfter(delay time.Duration) error {
+	return &RetryAfterError{Delay: delay}
+}
+
+// outcome is how a processed message is settled
+type outcome int
+
+const (
+	outcomeAck outcome = iota
+	outcomeDiscard
+	outcomeRequeue
+	outcomeRetry
+	outcomeDeadLetter
+)
+
+// decide returns the outcome of the processing error and the delay before the message is settled
+func (c *consumer) decide(err error) (outcome, time.Duration) {
+	var retryAfter *RetryAfterError
+
+	switch {
+	case err == nil:
+		return outcomeAck, 0
+	case errors.Is(err, ErrDiscard):
+		return outcomeDiscard, 0
+	case errors.Is(err, ErrRequeue):
+		return outcomeRequeue, 0
+	case errors.Is(err, ErrDeadLetter), errors.Is(err, ErrPoisonMessage):
+		// retrying will never succeed
+		return outcomeDeadLetter, 0
+	case errors.Is(err, errMessageInProgress):
+		// the duplicate is still being processed, check the message again later without spending a retry
+		return outcomeRequeue, c.options.RetryDelay
+	case errors.As(err, &retryAfter):
+		return outcomeRetry, retryAfter.Delay
+	case c.options.RetryOnError:
+		return outcomeRetry, c.options.RetryDelay
+	default:
+		return outcomeDiscard, 0
+	}
+}
+
+// sleep waits for the delay before settling a message, it returns false if the consumer is stopped meanwhile.
+// The message is left unacknowledged then, so the broker redelivers it once the channel is closed.
+func sleep(ctx context.Context, stopChan <-chan struct{}, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-stopChan:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func stopped(ctx context.Context, stopChan <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// settle acknowledges or rejects the message according to the outcome
+// and republishes it if it has to be retried.
+func (c *consumer) settle(msg amqp.Delivery, o outcome) {
+	metric := c.broker.clientMetric()
+	queueName := c.queue.Name()
+
+	if o == outcomeRetry {
+		remainingRetries := c.getRemainingRetries(msg)
+
+		switch {
+		case remainingRetries > 0:
+			if err := c.queue.PublishWithConfig(msg.Body, retryPublishConfig(msg, int(remainingRetries-1))); err != nil {
+				// the message is requeued instead, so it's not lost
+				log.Error(err)
+				o = outcomeRequeue
+			}
+		case remainingRetries == 0:
+			o = outcomeDiscard
+		default:
+			// infinite retries don't need to be counted, so the message is requeued
+			o = outcomeRequeue
+		}
+	}
+
+	var err error
+	switch o {
+	case outcomeAck:
+		err = msg.Ack(false)
+		metric.Acked(queueName, 1)
+	case outcomeDiscard:
+		err = msg.Ack(false)
+		metric.Discarded(queueName)
+	case outcomeRetry:
+		err = msg.Ack(false)
+		metric.Retried(queueName)
+	case outcomeRequeue:
+		err = msg.Reject(true)
+		metric.Nacked(queueName, true)
+	case outcomeDeadLetter:
+		err = msg.Reject(false)
+		metric.DeadLettered(queueName)
+	}
+
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,100 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumer_Decide(t *testing.T) {
+	options := DefaultConsumerOptions(1)
+	c := &consumer{options: options}
+
+	tests := []struct {
+		err     error
+		outcome outcome
+		delay   time.Duration
+	}{
+		{nil, outcomeAck, 0},
+		{fmt.Errorf("%w: outdated", ErrDiscard), outcomeDiscard, 0},
+		{ErrRequeue, outcomeRequeue, 0},
+		{ErrDeadLetter, outcomeDeadLetter, 0},
+		{ErrPoisonMessage, outcomeDeadLetter, 0},
+		{fmt.Errorf("node is syncing: %w", RetryAfter(time.Minute)), outcomeRetry, time.Minute},
+		{errors.New("failed"), outcomeRetry, time.Second},
+	}
+
+	for _, tt := range tests {
+		o, delay := c.decide(tt.err)
+		assert.Equal(t, tt.outcome, o, "%v", tt.err)
+		assert.Equal(t, tt.delay, delay, "%v", tt.err)
+	}
+
+	options.RetryOnError = false
+	o, _ := c.decide(errors.New("failed"))
+	assert.Equal(t, outcomeDiscard, o)
+	o, _ = c.decide(RetryAfter(time.Second))
+	assert.Equal(t, outcomeRetry, o)
+}
+
+func TestConsumer_Outcomes(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.Declare())
+
+	var requeued int32
+	options := DefaultConsumerOptions(1)
+	options.MaxRetries = 1
+
+	consumer := broker.InitDeliveryConsumer("deposits", options, DeliveryProcessorFunc(
+		func(_ context.Context, d Delivery) error {
+			switch string(d.Body) {
+			case "requeue":
+				if atomic.AddInt32(&requeued, 1) == 1 {
+					return ErrRequeue
+				}
+				return nil
+			case "discard":
+				return ErrDiscard
+			case "retry":
+				if d.RemainingRetries > 0 {
+					return RetryAfter(time.Millisecond)
+				}
+				return nil
+			}
+			return ErrDeadLetter
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+
+	for _, body := range []string{"requeue", "discard", "retry", "invalid"} {
+		require.NoError(t, queue.Publish([]byte(body)))
+	}
+
+	// requeue, discard, retry and its republished copy are acknowledged, requeue and invalid are rejected
+	require.NoError(t, broker.WaitForAck(ctx, "deposits", 4))
+	require.NoError(t, broker.WaitForNack(ctx, "deposits", 2))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requeued))
+	assert.Len(t, broker.PublishedMessages("deposits"), 5)
+}
+
+func TestSleep(t *testing.T) {
+	assert.True(t, sleep(context.Background(), nil, 0))
+	assert.True(t, sleep(context.Background(), nil, time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, sleep(ctx, nil, time.Hour), "a long retry delay must not hold back the shutdown")
+
+	stopChan := make(chan struct{})
+	close(stopChan)
+	assert.False(t, sleep(context.Background(), stopChan, time.Hour), "a long retry delay must not hold back the reconnect")
+}
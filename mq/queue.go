@@ -1,6 +1,10 @@
 package mq
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 type queue struct {
 	name   QueueName
@@ -24,7 +28,7 @@ func (q *queue) Declare() error {
 }
 
 func (q *queue) DeclareWithConfig(cfg DeclareConfig) error {
-	_, err := q.client.amqpChan.QueueDeclare(
+	_, err := q.client.managementChannel().QueueDeclare(
 		string(q.name),
 		cfg.Durable,
 		cfg.AutoDelete,
@@ -36,11 +40,15 @@ func (q *queue) DeclareWithConfig(cfg DeclareConfig) error {
 }
 
 func (q *queue) Publish(body []byte) error {
-	return publish(q.client.amqpChan, "", ExchangeKey(q.name), body)
+	return q.client.publish("", ExchangeKey(q.name), body)
 }
 
 func (q *queue) PublishWithConfig(body []byte, cfg PublishConfig) error {
-	return publishWithConfig(q.client.amqpChan, "", ExchangeKey(q.name), body, cfg)
+	return q.client.publishWithConfig(context.Background(), "", ExchangeKey(q.name), body, cfg)
+}
+
+func (q *queue) PublishWithContext(ctx context.Context, body []byte, cfg PublishConfig) error {
+	return q.client.publishWithConfig(ctx, "", ExchangeKey(q.name), body, cfg)
 }
 
 func (q *queue) HealthCheck() error {
@@ -59,6 +67,34 @@ type DeclareConfig struct {
 	Args       map[string]interface{}
 }
 
+// QuorumDeclareConfig declares a replicated quorum queue, quorum queues are always durable.
+func QuorumDeclareConfig() DeclareConfig {
+	return DeclareConfig{Durable: true}.WithArg(argQueueType, queueTypeQuorum)
+}
+
+// WithMaxPriority enables message priorities from 0 up to maxPriority, see PublishConfig.Priority.
+// RabbitMQ recommends to keep maxPriority below 10. Quorum queues don't support priorities.
+func (cfg DeclareConfig) WithMaxPriority(maxPriority uint8) DeclareConfig {
+	return cfg.WithArg(argMaxPriority, int32(maxPriority))
+}
+
+// WithMessageTTL expires messages which stay in the queue longer than ttl.
+func (cfg DeclareConfig) WithMessageTTL(ttl time.Duration) DeclareConfig {
+	return cfg.WithArg(argMessageTTL, ttl.Milliseconds())
+}
+
+// WithArg returns a copy of the config with the queue argument set.
+func (cfg DeclareConfig) WithArg(key string, value interface{}) DeclareConfig {
+	args := make(map[string]interface{}, len(cfg.Args)+1)
+	for k, v := range cfg.Args {
+		args[k] = v
+	}
+	args[key] = value
+	cfg.Args = args
+
+	return cfg
+}
+
 type DeliveryMode uint8
 
 const (
@@ -66,9 +102,37 @@ const (
 	DeliveryModePersistent DeliveryMode = 2
 )
 
+const (
+	argQueueType    = "x-queue-type"
+	argMaxPriority  = "x-max-priority"
+	argMessageTTL   = "x-message-ttl"
+	queueTypeQuorum = "quorum"
+)
+
 type PublishConfig struct {
 	// MaxRetries defines the maximum number of retries after processing failures.
 	// Overrides the value of consumer's config.
 	MaxRetries   *int
 	DeliveryMode DeliveryMode
+	// ContentType of the message body, defaults to text/plain.
+	ContentType     string
+	ContentEncoding string
+	AppID           string
+
+	// Priority requires the queue to be declared with DeclareConfig.WithMaxPriority.
+	Priority uint8
+	// Expiration discards the message if it's not consumed in time, zero means no expiration.
+	Expiration time.Duration
+
+	// Mandatory makes the publish fail with ErrUnroutable if the message isn't routed to any queue.
+	// The publish waits for the broker confirmation, which makes it slower.
+	Mandatory bool
+
+	MessageID     string
+	CorrelationID string
+	// ReplyTo is the queue the response should be sent to, see RPCClient.
+	ReplyTo string
+	// Headers are sent along with the message, x-remaining-retries is overridden by MaxRetries.
+	Headers   map[string]interface{}
+	Timestamp time.Time
 }
@@ -0,0 +1,66 @@
+package mq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeclareConfig(t *testing.T) {
+	quorum := QuorumDeclareConfig()
+	assert.True(t, quorum.Durable)
+	assert.Equal(t, "quorum", quorum.Args["x-queue-type"])
+
+	base := DeclareConfig{Durable: true, Args: map[string]interface{}{"x-dead-letter-exchange": "dlx"}}
+	cfg := base.WithMaxPriority(5).WithMessageTTL(time.Minute)
+	assert.Equal(t, map[string]interface{}{
+		"x-dead-letter-exchange": "dlx",
+		"x-max-priority":         int32(5),
+		"x-message-ttl":          int64(60000),
+	}, cfg.Args)
+
+	// the original config must not be modified
+	assert.Len(t, base.Args, 1)
+}
+
+func TestNewPublishing(t *testing.T) {
+	maxRetries := 2
+	p := newPublishing([]byte("body"), PublishConfig{
+		MaxRetries:      &maxRetries,
+		ContentEncoding: "gzip",
+		AppID:           "api",
+		Priority:        3,
+		Expiration:      1500 * time.Millisecond,
+	})
+
+	assert.Equal(t, amqp.Persistent, p.DeliveryMode)
+	assert.Equal(t, ContentTypeText, p.ContentType)
+	assert.Equal(t, "gzip", p.ContentEncoding)
+	assert.Equal(t, "api", p.AppId)
+	assert.Equal(t, uint8(3), p.Priority)
+	assert.Equal(t, "1500", p.Expiration)
+	assert.Equal(t, 2, p.Headers[headerRemainingRetries])
+
+	assert.Empty(t, newPublishing(nil, PublishConfig{}).Expiration)
+}
+
+func TestMemoryBroker_Mandatory(t *testing.T) {
+	broker := NewMemoryBroker()
+	exchange := broker.InitExchange("deposits")
+	require.NoError(t, exchange.Declare(ExchangeKindDirect))
+	publisher := ExchangePublisher(exchange, "btc")
+
+	assert.NoError(t, publisher.PublishWithConfig([]byte("dropped"), PublishConfig{}))
+
+	err := publisher.PublishWithConfig([]byte("unroutable"), PublishConfig{Mandatory: true})
+	assert.True(t, errors.Is(err, ErrUnroutable))
+
+	queue := broker.InitQueue("btc")
+	require.NoError(t, queue.Declare())
+	require.NoError(t, exchange.BindWithKey([]Queue{queue}, "btc"))
+	assert.NoError(t, publisher.PublishWithConfig([]byte("routed"), PublishConfig{Mandatory: true}))
+}
@@ -0,0 +1,186 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var (
+	// ErrReconnecting is returned by publishes while the client is reconnecting,
+	// if the reconnection doesn't finish within the publish reconnect timeout.
+	ErrReconnecting = errors.New("mq client is reconnecting")
+	// ErrConnectionClosed is returned by publishes after the client was closed or failed to reconnect.
+	ErrConnectionClosed = errors.New("mq connection is closed")
+)
+
+// ReconnectPolicy defines the delays between reconnection attempts.
+// The delay grows exponentially from InitialDelay by Multiplier up to MaxDelay,
+// and is randomized by +/- Jitter share of it.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter is between 0 and 1, e.g. 0.2 randomizes the delay by +/- 20%.
+	Jitter float64
+
+	// MaxAttempts is the number of attempts before giving up.
+	// A negative value is equal to infinite attempts.
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy makes 5 attempts with 30 seconds delay.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 30 * time.Second,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   1,
+		MaxAttempts:  5,
+	}
+}
+
+// ExponentialReconnectPolicy retries infinitely with exponential backoff from 1 second up to 1 minute.
+func ExponentialReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     time.Minute,
+		Multiplier:   2,
+		Jitter:       0.2,
+		MaxAttempts:  -1,
+	}
+}
+
+func (p ReconnectPolicy) validate() error {
+	switch {
+	case p.InitialDelay < 0 || p.MaxDelay < p.InitialDelay:
+		return fmt.Errorf("invalid reconnect delays: initial %s, max %s", p.InitialDelay, p.MaxDelay)
+	case p.Multiplier < 1:
+		return fmt.Errorf("invalid reconnect delay multiplier: %v", p.Multiplier)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("invalid reconnect jitter: %v", p.Jitter)
+	case p.MaxAttempts == 0:
+		return errors.New("reconnect max attempts must not be zero")
+	}
+
+	return nil
+}
+
+// delay returns the delay before the attempt, starting from 0
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	d := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt))
+	if d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+func (p ReconnectPolicy) hasAttempt(attempt int) bool {
+	return p.MaxAttempts < 0 || attempt < p.MaxAttempts
+}
+
+type ConnectionState int
+
+const (
+	ConnectionStateConnected ConnectionState = iota
+	ConnectionStateReconnecting
+	// ConnectionStateFailed means all reconnection attempts failed, the client is unusable.
+	ConnectionStateFailed
+	// ConnectionStateClosed means the client was closed by the context of ListenConnection.
+	ConnectionStateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionStateConnected:
+		return "connected"
+	case ConnectionStateReconnecting:
+		return "reconnecting"
+	case ConnectionStateFailed:
+		return "failed"
+	case ConnectionStateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// ConnectionEvent is a change of the connection state.
+type ConnectionEvent struct {
+	State ConnectionState
+	// Attempt is the number of the reconnection attempt, starting from 1.
+	Attempt int
+	// Err is the reason of the state change, if any.
+	Err error
+}
+
+// ConnectionListener is called synchronously on every connection event, so it must not block.
+type ConnectionListener func(event ConnectionEvent)
+
+func (c *Client) setState(event ConnectionEvent) {
+	c.stateMu.Lock()
+	previous := c.state
+	c.state = event.State
+
+	switch {
+	case event.State == ConnectionStateReconnecting && previous != ConnectionStateReconnecting:
+		c.stateChanged = make(chan struct{})
+	case event.State != ConnectionStateReconnecting && previous == ConnectionStateReconnecting:
+		close(c.stateChanged)
+	}
+
+	listeners := c.connListeners
+	c.stateMu.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// State returns the current connection state.
+func (c *Client) State() ConnectionState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.state
+}
+
+// waitConnected blocks publishes while the client is reconnecting, up to the publish reconnect timeout.
+func (c *Client) waitConnected(ctx context.Context) error {
+	c.stateMu.Lock()
+	state, changed := c.state, c.stateChanged
+	c.stateMu.Unlock()
+
+	switch state {
+	case ConnectionStateConnected:
+		return nil
+	case ConnectionStateFailed, ConnectionStateClosed:
+		return ErrConnectionClosed
+	}
+
+	if c.publishReconnectTimeout <= 0 {
+		return ErrReconnecting
+	}
+
+	timer := time.NewTimer(c.publishReconnectTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+		if state := c.State(); state != ConnectionStateConnected {
+			return fmt.Errorf("%w: %s", ErrConnectionClosed, state)
+		}
+		return nil
+	case <-timer.C:
+		return ErrReconnecting
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
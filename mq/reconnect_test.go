@@ -0,0 +1,104 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectPolicy_Delay(t *testing.T) {
+	policy := ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+		Multiplier:   2,
+		MaxAttempts:  -1,
+	}
+
+	assert.Equal(t, time.Second, policy.delay(0))
+	assert.Equal(t, 2*time.Second, policy.delay(1))
+	assert.Equal(t, 4*time.Second, policy.delay(2))
+	assert.Equal(t, 5*time.Second, policy.delay(3))
+	assert.True(t, policy.hasAttempt(1000))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := policy.delay(0)
+		assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, d)
+	}
+
+	assert.Equal(t, 30*time.Second, DefaultReconnectPolicy().delay(10))
+	assert.False(t, DefaultReconnectPolicy().hasAttempt(5))
+}
+
+func TestReconnectPolicy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultReconnectPolicy().validate())
+	assert.NoError(t, ExponentialReconnectPolicy().validate())
+
+	policy := DefaultReconnectPolicy()
+	policy.MaxAttempts = 0
+	assert.Error(t, policy.validate())
+
+	policy = DefaultReconnectPolicy()
+	policy.Multiplier = 0.5
+	assert.Error(t, policy.validate())
+
+	policy = DefaultReconnectPolicy()
+	policy.MaxDelay = time.Second
+	assert.Error(t, policy.validate())
+}
+
+func TestClient_WaitConnected(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []ConnectionEvent
+	)
+	c := &Client{
+		publishReconnectTimeout: time.Second,
+		connListeners: []ConnectionListener{func(e ConnectionEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		}},
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, c.waitConnected(ctx))
+
+	c.setState(ConnectionEvent{State: ConnectionStateReconnecting, Attempt: 1})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.setState(ConnectionEvent{State: ConnectionStateConnected, Attempt: 1})
+	}()
+	assert.NoError(t, c.waitConnected(ctx))
+
+	c.setState(ConnectionEvent{State: ConnectionStateReconnecting, Attempt: 1})
+	c.publishReconnectTimeout = 10 * time.Millisecond
+	assert.True(t, errors.Is(c.waitConnected(ctx), ErrReconnecting))
+
+	c.publishReconnectTimeout = 0
+	assert.True(t, errors.Is(c.waitConnected(ctx), ErrReconnecting))
+
+	c.setState(ConnectionEvent{State: ConnectionStateFailed})
+	assert.True(t, errors.Is(c.waitConnected(ctx), ErrConnectionClosed))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []ConnectionState{
+		ConnectionStateReconnecting,
+		ConnectionStateConnected,
+		ConnectionStateReconnecting,
+		ConnectionStateFailed,
+	}, states(events))
+}
+
+func states(events []ConnectionEvent) []ConnectionState {
+	result := make([]ConnectionState, len(events))
+	for i, e := range events {
+		result[i] = e.State
+	}
+	return result
+}
@@ -0,0 +1,279 @@
+package mq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const headerRPCError = "x-rpc-error"
+
+// ErrRPCHandler is returned by RPCClient.Call when the handler of the server failed.
+var ErrRPCHandler = errors.New("rpc handler failed")
+
+// rpcBroker is implemented by Client and MemoryBroker
+type rpcBroker interface {
+	broker
+	// declareReplyQueue declares an exclusive auto-delete queue with a name generated by the broker
+	declareReplyQueue() (QueueName, error)
+}
+
+// publishFunc publishes a message to the exchange, the default exchange routes by the queue name
+type publishFunc func(ctx context.Context, exchange ExchangeName, key ExchangeKey, body []byte, cfg PublishConfig) error
+
+// RPCClient sends requests and waits for their replies.
+// Replies are received on an exclusive auto-delete queue, so RPCClient has to be started
+// with StartConsumers, which also redeclares the reply queue after a reconnect.
+// Calls pending during a reconnect don't receive a reply and end with the context.
+type RPCClient struct {
+	broker rpcBroker
+
+	mu         sync.Mutex
+	replyQueue QueueName
+	pending    map[string]chan Delivery
+
+	stopChan chan struct{}
+}
+
+func newRPCClient(broker rpcBroker) *RPCClient {
+	return &RPCClient{
+		broker:  broker,
+		pending: map[string]chan Delivery{},
+	}
+}
+
+func (r *RPCClient) Start(ctx context.Context) error {
+	replyQueue, err := r.broker.declareReplyQueue()
+	if err != nil {
+		return fmt.Errorf("declare reply queue: %w", err)
+	}
+
+	stopChan := make(chan struct{})
+	replies, err := r.broker.consume(ctx, stopChan, replyQueue, 0)
+	if err != nil {
+		return fmt.Errorf("consume reply queue: %w", err)
+	}
+
+	r.mu.Lock()
+	r.replyQueue = replyQueue
+	r.stopChan = stopChan
+	r.mu.Unlock()
+
+	go r.consume(ctx, replies, stopChan)
+
+	log.Infof("Started MQ RPC client with reply queue %s", replyQueue)
+
+	return nil
+}
+
+func (r *RPCClient) Reconnect(ctx context.Context) error {
+	r.mu.Lock()
+	if r.stopChan != nil {
+		close(r.stopChan)
+		r.stopChan = nil
+	}
+	r.mu.Unlock()
+
+	return r.Start(ctx)
+}
+
+func (r *RPCClient) HealthCheck() error {
+	return r.broker.HealthCheck()
+}
+
+// Call publishes the request and waits for the reply until the context is done.
+func (r *RPCClient) Call(ctx context.Context, publisher Publisher, body Message) (Message, error) {
+	reply, err := r.CallWithConfig(ctx, publisher, body, PublishConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Body, nil
+}
+
+// CallWithConfig is Call with custom publish config, ReplyTo and CorrelationID of the config are overridden.
+// The request is transient by default, since nobody waits for its reply after a broker restart.
+func (r *RPCClient) CallWithConfig(ctx context.Context, publisher Publisher, body Message, cfg PublishConfig) (Delivery, error) {
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	replyChan := make(chan Delivery, 1)
+
+	r.mu.Lock()
+	replyQueue := r.replyQueue
+	r.pending[correlationID] = replyChan
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, correlationID)
+		r.mu.Unlock()
+	}()
+
+	if replyQueue == "" {
+		return Delivery{}, errors.New("rpc client is not started")
+	}
+
+	cfg.ReplyTo = string(replyQueue)
+	cfg.CorrelationID = correlationID
+	cfg.Headers = InjectContext(ctx, cfg.Headers)
+	if cfg.DeliveryMode == 0 {
+		cfg.DeliveryMode = DeliveryModeTransient
+	}
+
+	if err := publisher.PublishWithConfig(body, cfg); err != nil {
+		return Delivery{}, fmt.Errorf("publish request: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return Delivery{}, fmt.Errorf("wait for reply: %w", ctx.Err())
+	case reply := <-replyChan:
+		if errMsg, ok := reply.Headers[headerRPCError].(string); ok {
+			return reply, fmt.Errorf("%w: %s", ErrRPCHandler, errMsg)
+		}
+
+		return reply, nil
+	}
+}
+
+func (r *RPCClient) consume(ctx context.Context, replies <-chan amqp.Delivery, stopChan chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopChan:
+			return
+		case msg, ok := <-replies:
+			if !ok {
+				return
+			}
+
+			if err := msg.Ack(false); err != nil {
+				log.Errorf("ack rpc reply: %v", err)
+			}
+
+			r.mu.Lock()
+			replyChan, ok := r.pending[msg.CorrelationId]
+			delete(r.pending, msg.CorrelationId)
+			r.mu.Unlock()
+
+			if !ok {
+				// the call has already timed out
+				log.Debugf("Dropped rpc reply with unknown correlation id %s", msg.CorrelationId)
+				continue
+			}
+
+			replyChan <- newDelivery(msg, 0)
+		}
+	}
+}
+
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate correlation id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// RPCHandler handles a request and returns the reply.
+type RPCHandler interface {
+	Handle(ctx context.Context, request Delivery) (Message, error)
+}
+
+// RPCHandlerFunc is an adapter to allow to use
+// an ordinary functions as mq RPCHandler.
+type RPCHandlerFunc func(ctx context.Context, request Delivery) (Message, error)
+
+func (f RPCHandlerFunc) Handle(ctx context.Context, request Delivery) (Message, error) {
+	return f(ctx, request)
+}
+
+// RPCServer is a DeliveryProcessor which sends the reply of the handler back to the caller.
+// Errors of the handler are sent to the caller as well, so the request is not retried.
+// It's only retried if the reply can't be published.
+type RPCServer struct {
+	publish publishFunc
+	handler RPCHandler
+}
+
+func newRPCServer(publish publishFunc, handler RPCHandler) *RPCServer {
+	return &RPCServer{
+		publish: publish,
+		handler: handler,
+	}
+}
+
+func (s *RPCServer) Process(ctx context.Context, request Delivery) error {
+	reply, err := s.handler.Handle(ctx, request)
+
+	if request.ReplyTo == "" {
+		// nobody waits for the reply
+		return err
+	}
+
+	cfg := PublishConfig{
+		DeliveryMode:  DeliveryModeTransient,
+		CorrelationID: request.CorrelationID,
+	}
+	if err != nil {
+		cfg.Headers = map[string]interface{}{headerRPCError: err.Error()}
+	}
+
+	if err := s.publish(ctx, "", ExchangeKey(request.ReplyTo), reply, cfg); err != nil {
+		return fmt.Errorf("publish rpc reply: %w", err)
+	}
+
+	return nil
+}
+
+// InitRPCClient inits a client for request/reply calls, it has to be started with StartConsumers.
+func (c *Client) InitRPCClient() *RPCClient {
+	return newRPCClient(c)
+}
+
+// InitRPCServer inits a consumer which replies to the requests of RPCClient.
+func (c *Client) InitRPCServer(queueName QueueName, options *ConsumerOptions, handler RPCHandler) Consumer {
+	return c.InitDeliveryConsumer(queueName, options, newRPCServer(c.publishWithConfig, handler))
+}
+
+func (c *Client) declareReplyQueue() (QueueName, error) {
+	q, err := c.managementChannel().QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return QueueName(q.Name), nil
+}
+
+// InitRPCClient inits a client for request/reply calls, it has to be started with StartConsumers.
+func (b *MemoryBroker) InitRPCClient() *RPCClient {
+	return newRPCClient(b)
+}
+
+// InitRPCServer inits a consumer which replies to the requests of RPCClient.
+func (b *MemoryBroker) InitRPCServer(queueName QueueName, options *ConsumerOptions, handler RPCHandler) Consumer {
+	return b.InitDeliveryConsumer(queueName, options, newRPCServer(b.publish, handler))
+}
+
+func (b *MemoryBroker) declareReplyQueue() (QueueName, error) {
+	id, err := newCorrelationID()
+	if err != nil {
+		return "", err
+	}
+
+	name := QueueName("amq.gen-" + id)
+	b.declareQueue(name, nil)
+
+	return name, nil
+}
@@ -0,0 +1,61 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPC(t *testing.T) {
+	broker := NewMemoryBroker()
+	requests := broker.InitQueue("rates")
+	require.NoError(t, requests.Declare())
+
+	server := broker.InitRPCServer("rates", DefaultConsumerOptions(2), RPCHandlerFunc(
+		func(_ context.Context, request Delivery) (Message, error) {
+			if len(request.Body) == 0 {
+				return nil, errors.New("empty request")
+			}
+			return Message(strings.ToUpper(string(request.Body))), nil
+		}))
+	client := broker.InitRPCClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, server, client))
+
+	reply, err := client.Call(ctx, requests, Message("btc"))
+	require.NoError(t, err)
+	assert.Equal(t, Message("BTC"), reply)
+
+	_, err = client.Call(ctx, requests, Message{})
+	assert.True(t, errors.Is(err, ErrRPCHandler))
+	assert.Contains(t, err.Error(), "empty request")
+}
+
+func TestRPC_Timeout(t *testing.T) {
+	broker := NewMemoryBroker()
+	requests := broker.InitQueue("rates")
+	require.NoError(t, requests.Declare())
+
+	client := broker.InitRPCClient()
+	require.NoError(t, broker.StartConsumers(context.Background(), client))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.Call(ctx, requests, Message("btc"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Empty(t, client.pending)
+
+	published, err := broker.consume(context.Background(), nil, "rates", 1)
+	require.NoError(t, err)
+	request := <-published
+	assert.NotEmpty(t, request.ReplyTo)
+	assert.NotEmpty(t, request.CorrelationId)
+}
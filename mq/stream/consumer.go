@@ -0,0 +1,464 @@
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+	"github.com/trustwallet/go-libs/metrics"
+	"github.com/trustwallet/go-libs/mq"
+)
+
+// minClaimIdle is the lowest ClaimIdle, Redis measures the idle time in milliseconds
+// and pending messages are claimed every ClaimIdle / 2.
+const minClaimIdle = 2 * time.Millisecond
+
+// ConsumerOptions mirrors mq.ConsumerOptions for streams.
+type ConsumerOptions struct {
+	Workers           int
+	RetryOnError      bool
+	RetryDelay        time.Duration
+	PerformanceMetric metrics.PerformanceMetric
+
+	// MaxRetries specifies the default number of retries for consuming a message.
+	// A negative value is equal to infinite retries.
+	MaxRetries int
+
+	// DeadLetterStream receives messages rejected with mq.ErrDeadLetter or mq.ErrPoisonMessage
+	// and messages which have run out of retries. Such messages are dropped if it's empty.
+	DeadLetterStream string
+
+	// ConsumerName identifies the consumer within the group, it's the host name with a random suffix by default.
+	ConsumerName string
+
+	// ClaimIdle is the time after which messages delivered but not acknowledged, e.g. by a crashed consumer,
+	// are claimed by other consumers of the group. It must exceed the processing time of messages,
+	// messages are read only when a worker is idle, so their idle time doesn't include waiting for a worker.
+	// It must be at least 2ms.
+	ClaimIdle time.Duration
+
+	// BlockTimeout is the maximum wait for new messages within a single read.
+	BlockTimeout time.Duration
+}
+
+func DefaultConsumerOptions(workers int) *ConsumerOptions {
+	return &ConsumerOptions{
+		Workers:           workers,
+		RetryOnError:      true,
+		RetryDelay:        time.Second,
+		MaxRetries:        -1,
+		PerformanceMetric: &metrics.NullablePerformanceMetric{},
+		ClaimIdle:         time.Minute,
+		BlockTimeout:      5 * time.Second,
+	}
+}
+
+type consumer struct {
+	stream    *Stream
+	group     string
+	name      string
+	options   *ConsumerOptions
+	processor mq.MessageProcessor
+
+	stopChan chan struct{}
+
+	// inFlight holds the IDs of the messages being processed, they aren't dispatched again when claimed
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+// workers receive the messages, idle holds a token per idle worker
+type workers struct {
+	messages chan message
+	idle     chan struct{}
+}
+
+// InitConsumer creates a consumer of the group, the group is created on Start if it doesn't exist
+// and starts from the beginning of the stream.
+func (s *Stream) InitConsumer(group string, options *ConsumerOptions, processor mq.MessageProcessor) mq.Consumer {
+	return &consumer{
+		stream:    s,
+		group:     group,
+		name:      options.ConsumerName,
+		options:   options,
+		processor: processor,
+		inFlight:  make(map[string]bool),
+	}
+}
+
+func (c *consumer) Start(ctx context.Context) error {
+	if c.options.Workers < 1 || c.options.ClaimIdle < minClaimIdle {
+		return fmt.Errorf("invalid consumer options: %d workers, claim idle %s", c.options.Workers, c.options.ClaimIdle)
+	}
+
+	if c.name == "" {
+		name, err := defaultConsumerName()
+		if err != nil {
+			return err
+		}
+		c.name = name
+	}
+
+	if err := c.stream.redis.XGroupCreate(ctx, c.stream.name, c.group, "0"); err != nil {
+		return fmt.Errorf("create group %s of stream %s: %w", c.group, c.stream.name, err)
+	}
+
+	stop := make(chan struct{})
+	c.stopChan = stop
+
+	w := workers{
+		messages: make(chan message),
+		idle:     make(chan struct{}, c.options.Workers),
+	}
+	for i := 0; i < c.options.Workers; i++ {
+		w.idle <- struct{}{}
+	}
+
+	go c.read(ctx, stop, w)
+	go c.claim(ctx, stop, w)
+	for i := 1; i <= c.options.Workers; i++ {
+		go c.consume(ctx, stop, w)
+	}
+
+	log.Infof("Started %d stream consumer workers for stream %s", c.options.Workers, c.stream.name)
+
+	return nil
+}
+
+func (c *consumer) Reconnect(ctx context.Context) error {
+	if c.stopChan != nil {
+		close(c.stopChan)
+	}
+
+	return c.Start(ctx)
+}
+
+func (c *consumer) HealthCheck() error {
+	if err := c.stream.redis.HealthCheck(); err != nil {
+		return fmt.Errorf("redis health check: %v", err)
+	}
+
+	return nil
+}
+
+// read fetches new messages of the group, at most one message per idle worker is fetched at once,
+// so fetched messages don't wait for a worker while their idle time approaches ClaimIdle
+func (c *consumer) read(ctx context.Context, stop <-chan struct{}, w workers) {
+	for {
+		tokens := c.acquire(ctx, stop, w)
+		if tokens == 0 {
+			return
+		}
+
+		entries, err := c.stream.redis.XReadGroup(ctx, c.stream.name, c.group, c.name,
+			int64(tokens), c.options.BlockTimeout)
+		if err != nil {
+			release(w, tokens)
+			if stopped(ctx, stop) {
+				return
+			}
+			log.WithError(err).Errorf("Read stream %s", c.stream.name)
+			sleep(ctx, stop, c.options.RetryDelay)
+			continue
+		}
+
+		if !c.dispatch(ctx, stop, entries, w, tokens) {
+			return
+		}
+	}
+}
+
+// claim periodically takes over messages pending for longer than ClaimIdle, one message per idle worker at once
+func (c *consumer) claim(ctx context.Context, stop <-chan struct{}, w workers) {
+	ticker := time.NewTicker(c.options.ClaimIdle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		start := "0-0"
+		for {
+			tokens := c.acquire(ctx, stop, w)
+			if tokens == 0 {
+				return
+			}
+
+			entries, next, err := c.stream.redis.XAutoClaim(ctx, c.stream.name, c.group, c.name,
+				c.options.ClaimIdle, start, int64(tokens))
+			if err != nil {
+				release(w, tokens)
+				if !stopped(ctx, stop) {
+					log.WithError(err).Errorf("Claim pending messages of stream %s", c.stream.name)
+				}
+				break
+			}
+
+			if len(entries) > 0 {
+				log.Infof("Claimed %d pending messages of stream %s", len(entries), c.stream.name)
+			}
+			if !c.dispatch(ctx, stop, entries, w, tokens) || next == "0-0" {
+				break
+			}
+			start = next
+		}
+	}
+}
+
+// acquire takes the tokens of the idle workers, waiting for at least one.
+// It returns 0 if the consumer has been stopped meanwhile.
+func (c *consumer) acquire(ctx context.Context, stop <-chan struct{}, w workers) int {
+	select {
+	case <-ctx.Done():
+		return 0
+	case <-stop:
+		return 0
+	case <-w.idle:
+	}
+
+	tokens := 1
+	for tokens < c.options.Workers {
+		select {
+		case <-w.idle:
+			tokens++
+		default:
+			return tokens
+		}
+	}
+
+	return tokens
+}
+
+func release(w workers, tokens int) {
+	for i := 0; i < tokens; i++ {
+		w.idle <- struct{}{}
+	}
+}
+
+// dispatch passes the entries to the workers, each entry takes one of the tokens and the unused ones are released.
+// Entries which are still processed by this consumer, e.g. claimed after ClaimIdle, are skipped.
+// It returns false if the consumer has been stopped meanwhile.
+func (c *consumer) dispatch(
+	ctx context.Context,
+	stop <-chan struct{},
+	entries []redis.StreamMessage,
+	w workers,
+	tokens int,
+) bool {
+	for _, entry := range entries {
+		msg, err := decodeMessage(entry)
+		if err != nil {
+			log.WithError(err).Errorf("Drop invalid message of stream %s", c.stream.name)
+			c.ack(ctx, entry.ID)
+			continue
+		}
+
+		if !c.track(msg.id) {
+			log.Warnf("Skip claimed message %s of stream %s, it's still processed", msg.id, c.stream.name)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			c.untrack(msg.id)
+			return false
+		case <-stop:
+			c.untrack(msg.id)
+			return false
+		case w.messages <- msg:
+			tokens--
+		}
+	}
+
+	release(w, tokens)
+	return true
+}
+
+// track marks the message as in flight, it returns false if it's already processed
+func (c *consumer) track(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inFlight[id] {
+		return false
+	}
+	c.inFlight[id] = true
+	return true
+}
+
+func (c *consumer) untrack(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inFlight, id)
+}
+
+func (c *consumer) consume(ctx context.Context, stop <-chan struct{}, w workers) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("Finished consuming stream %s", c.stream.name)
+			return
+		case <-stop:
+			log.Infof("Force stopped consuming stream %s", c.stream.name)
+			return
+		case msg := <-w.messages:
+			err := c.process(msg)
+			if err != nil {
+				log.Error(err)
+			}
+			c.settle(ctx, stop, msg, err)
+			c.untrack(msg.id)
+			release(w, 1)
+		}
+	}
+}
+
+func (c *consumer) process(msg message) error {
+	metric := c.options.PerformanceMetric
+	if metric == nil {
+		metric = &metrics.NullablePerformanceMetric{}
+	}
+
+	defer metric.Duration(metric.Start())
+
+	err := c.processor.Process(msg.body)
+	if err != nil {
+		metric.Failure()
+	} else {
+		metric.Success()
+	}
+
+	return err
+}
+
+// settle acknowledges the message, republishing it first if it has to be retried.
+// The message is left pending if republishing fails, so it's claimed again after ClaimIdle.
+func (c *consumer) settle(ctx context.Context, stop <-chan struct{}, msg message, err error) {
+	var retryAfter *mq.RetryAfterError
+
+	switch {
+	case err == nil, errors.Is(err, mq.ErrDiscard):
+	case errors.Is(err, mq.ErrRequeue):
+		if err := c.stream.publish(ctx, msg.body, msg.remainingRetries); err != nil {
+			log.Error(err)
+			return
+		}
+	case errors.Is(err, mq.ErrDeadLetter), errors.Is(err, mq.ErrPoisonMessage):
+		if !c.deadLetter(ctx, msg, err) {
+			return
+		}
+	case errors.As(err, &retryAfter):
+		if !c.retry(ctx, stop, msg, err, retryAfter.Delay) {
+			return
+		}
+	case c.options.RetryOnError:
+		if !c.retry(ctx, stop, msg, err, c.options.RetryDelay) {
+			return
+		}
+	}
+
+	c.ack(ctx, msg.id)
+}
+
+// retry republishes the message after the delay spending a retry, messages without retries are dead lettered
+func (c *consumer) retry(ctx context.Context, stop <-chan struct{}, msg message, cause error, delay time.Duration) bool {
+	remainingRetries := c.options.MaxRetries
+	if msg.remainingRetries != nil {
+		remainingRetries = *msg.remainingRetries
+	}
+
+	if remainingRetries == 0 {
+		return c.deadLetter(ctx, msg, cause)
+	}
+	if remainingRetries > 0 {
+		remainingRetries--
+	}
+
+	if !sleep(ctx, stop, delay) {
+		return false
+	}
+
+	if err := c.stream.publish(ctx, msg.body, &remainingRetries); err != nil {
+		log.Error(err)
+		return false
+	}
+
+	return true
+}
+
+func (c *consumer) deadLetter(ctx context.Context, msg message, cause error) bool {
+	if c.options.DeadLetterStream == "" {
+		return true
+	}
+
+	values := map[string]interface{}{
+		fieldBody:  []byte(msg.body),
+		fieldError: cause.Error(),
+	}
+	if _, err := c.stream.redis.XAdd(ctx, c.options.DeadLetterStream, values, 0); err != nil {
+		log.WithError(err).Errorf("Dead letter message %s of stream %s", msg.id, c.stream.name)
+		return false
+	}
+
+	return true
+}
+
+func (c *consumer) ack(ctx context.Context, id string) {
+	if err := c.stream.redis.XAck(ctx, c.stream.name, c.group, id); err != nil {
+		log.WithError(err).Errorf("Ack message %s of stream %s", id, c.stream.name)
+	}
+}
+
+func stopped(ctx context.Context, stop <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the delay, it returns false if the consumer is stopped meanwhile
+func sleep(ctx context.Context, stop <-chan struct{}, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func defaultConsumerName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("get host name: %w", err)
+	}
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate consumer name: %w", err)
+	}
+
+	return host + "-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,257 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+	"github.com/trustwallet/go-libs/mq"
+)
+
+func newTestRedis(t *testing.T) *redis.Redis {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	r, err := redis.Init(context.Background(), fmt.Sprintf("redis://%s", mr.Addr()))
+	require.NoError(t, err)
+
+	return r
+}
+
+func testConsumerOptions() *ConsumerOptions {
+	options := DefaultConsumerOptions(2)
+	options.RetryDelay = time.Millisecond
+	options.BlockTimeout = 10 * time.Millisecond
+	options.ConsumerName = "test"
+	return options
+}
+
+type recordingProcessor struct {
+	mu       sync.Mutex
+	messages []string
+	process  func(attempt int, body mq.Message) error
+}
+
+func (p *recordingProcessor) Process(body mq.Message) error {
+	p.mu.Lock()
+	p.messages = append(p.messages, string(body))
+	attempt := len(p.messages)
+	p.mu.Unlock()
+
+	if p.process == nil {
+		return nil
+	}
+	return p.process(attempt, body)
+}
+
+func (p *recordingProcessor) processed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.messages...)
+}
+
+func waitPending(t *testing.T, s *Stream, group string, expected int) {
+	require.Eventually(t, func() bool {
+		pending, err := s.Pending(context.Background(), group, 10)
+		return err == nil && len(pending) == expected
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConsumer(t *testing.T) {
+	r := newTestRedis(t)
+	s := NewStream(r, "deposits", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, body := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Publish(ctx, mq.Message(body)))
+	}
+
+	processor := &recordingProcessor{}
+	consumer := s.InitConsumer("notifier", testConsumerOptions(), processor)
+	require.NoError(t, consumer.Start(ctx))
+	require.NoError(t, consumer.HealthCheck())
+
+	require.Eventually(t, func() bool {
+		return len(processor.processed()) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, processor.processed())
+	waitPending(t, s, "notifier", 0)
+}
+
+func TestConsumer_Retries(t *testing.T) {
+	r := newTestRedis(t)
+	s := NewStream(r, "deposits", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	options := testConsumerOptions()
+	options.MaxRetries = 2
+	options.DeadLetterStream = "deposits:dead"
+
+	processor := &recordingProcessor{process: func(int, mq.Message) error {
+		return errors.New("node is down")
+	}}
+	require.NoError(t, s.InitConsumer("notifier", options, processor).Start(ctx))
+	require.NoError(t, s.Publish(ctx, mq.Message("a")))
+
+	dead := NewStream(r, "deposits:dead", 0)
+	require.Eventually(t, func() bool {
+		length, err := dead.Len(ctx)
+		return err == nil && length == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"a", "a", "a"}, processor.processed(), "message must be retried twice")
+	waitPending(t, s, "notifier", 0)
+}
+
+func TestConsumer_Outcomes(t *testing.T) {
+	r := newTestRedis(t)
+	s := NewStream(r, "deposits", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	options := testConsumerOptions()
+	options.RetryOnError = false
+	options.DeadLetterStream = "deposits:dead"
+
+	processor := &recordingProcessor{process: func(attempt int, body mq.Message) error {
+		switch string(body) {
+		case "requeue":
+			if attempt == 1 {
+				return mq.ErrRequeue
+			}
+			return nil
+		case "dead":
+			return fmt.Errorf("%w: unknown asset", mq.ErrDeadLetter)
+		case "retry after":
+			if attempt <= 2 {
+				return mq.RetryAfter(time.Millisecond)
+			}
+			return nil
+		default:
+			return errors.New("failed")
+		}
+	}}
+
+	consumer := s.InitConsumer("notifier", options, processor)
+	require.NoError(t, consumer.Start(ctx))
+
+	for _, body := range []string{"requeue", "dead", "discard"} {
+		require.NoError(t, s.Publish(ctx, mq.Message(body)))
+		require.Eventually(t, func() bool {
+			pending, err := s.Pending(ctx, "notifier", 10)
+			processed := processor.processed()
+			return err == nil && len(pending) == 0 && processed[len(processed)-1] == body
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	assert.Equal(t, []string{"requeue", "requeue", "dead", "discard"}, processor.processed())
+
+	length, err := NewStream(r, "deposits:dead", 0).Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+}
+
+func TestConsumer_ClaimsStuckMessages(t *testing.T) {
+	r := newTestRedis(t)
+	s := NewStream(r, "deposits", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, r.XGroupCreate(ctx, "deposits", "notifier", "0"))
+	require.NoError(t, s.Publish(ctx, mq.Message("a")))
+
+	// the message is delivered to a consumer which crashes before acknowledging it
+	messages, err := r.XReadGroup(ctx, "deposits", "notifier", "crashed", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	waitPending(t, s, "notifier", 1)
+
+	options := testConsumerOptions()
+	options.ClaimIdle = 50 * time.Millisecond
+
+	processor := &recordingProcessor{}
+	require.NoError(t, s.InitConsumer("notifier", options, processor).Start(ctx))
+
+	require.Eventually(t, func() bool {
+		return len(processor.processed()) == 1
+	}, time.Second, 10*time.Millisecond)
+	waitPending(t, s, "notifier", 0)
+}
+
+func TestConsumer_ReadsForIdleWorkers(t *testing.T) {
+	r := newTestRedis(t)
+	s := NewStream(r, "deposits", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, body := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Publish(ctx, mq.Message(body)))
+	}
+
+	release := make(chan struct{})
+	processor := &recordingProcessor{process: func(int, mq.Message) error {
+		<-release
+		return nil
+	}}
+	options := testConsumerOptions()
+	options.Workers = 1
+	require.NoError(t, s.InitConsumer("notifier", options, processor).Start(ctx))
+
+	require.Eventually(t, func() bool {
+		return len(processor.processed()) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	waitPending(t, s, "notifier", 1)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return len(processor.processed()) == 3
+	}, time.Second, 10*time.Millisecond)
+	waitPending(t, s, "notifier", 0)
+}
+
+func TestConsumer_SkipsClaimedMessagesInFlight(t *testing.T) {
+	r := newTestRedis(t)
+	s := NewStream(r, "deposits", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, s.Publish(ctx, mq.Message("a")))
+
+	options := testConsumerOptions()
+	options.ClaimIdle = 20 * time.Millisecond
+	processor := &recordingProcessor{process: func(int, mq.Message) error {
+		// the message becomes idle for longer than ClaimIdle while it's processed
+		time.Sleep(150 * time.Millisecond)
+		return nil
+	}}
+	require.NoError(t, s.InitConsumer("notifier", options, processor).Start(ctx))
+
+	waitPending(t, s, "notifier", 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"a"}, processor.processed(), "the message must not be processed twice")
+}
+
+func TestConsumer_InvalidOptions(t *testing.T) {
+	s := NewStream(newTestRedis(t), "deposits", 0)
+
+	err := s.InitConsumer("notifier", &ConsumerOptions{}, &recordingProcessor{}).Start(context.Background())
+	assert.Error(t, err)
+
+	// the claim ticker runs every ClaimIdle / 2, which must not be zero
+	options := DefaultConsumerOptions(1)
+	options.ClaimIdle = time.Nanosecond
+	err = s.InitConsumer("notifier", options, &recordingProcessor{}).Start(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+// Package stream runs mq consumers on Redis Streams, it suits workloads which don't need RabbitMQ.
+//
+// Messages are processed by mq.MessageProcessor and settled by the errors of mq, e.g. mq.ErrDiscard,
+// mq.ErrDeadLetter or mq.RetryAfter, the same way as by mq consumers.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/trustwallet/go-libs/cache/redis"
+	"github.com/trustwallet/go-libs/mq"
+)
+
+const (
+	fieldBody             = "body"
+	fieldRemainingRetries = "remaining_retries"
+	fieldError            = "error"
+)
+
+// Redis is implemented by *redis.Redis.
+type Redis interface {
+	XAdd(ctx context.Context, stream string, values map[string]interface{}, maxLen int64) (string, error)
+	XLen(ctx context.Context, stream string) (int64, error)
+	XGroupCreate(ctx context.Context, stream, group, start string) error
+	XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]redis.StreamMessage, error)
+	XAck(ctx context.Context, stream, group string, ids ...string) error
+	XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]redis.StreamMessage, string, error)
+	XPending(ctx context.Context, stream, group string, count int64) ([]redis.PendingMessage, error)
+	HealthCheck() error
+}
+
+// Stream is a queue backed by a Redis stream.
+type Stream struct {
+	redis  Redis
+	name   string
+	maxLen int64
+}
+
+// NewStream creates the queue of the stream, a positive maxLen trims the stream to approximately maxLen messages.
+// Trimming drops the oldest messages even if they haven't been processed yet.
+func NewStream(r Redis, name string, maxLen int64) *Stream {
+	return &Stream{redis: r, name: name, maxLen: maxLen}
+}
+
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// Publish appends the message to the stream, its retries are limited by ConsumerOptions.MaxRetries of the consumers.
+func (s *Stream) Publish(ctx context.Context, body mq.Message) error {
+	return s.publish(ctx, body, nil)
+}
+
+// Len returns the number of messages kept in the stream including processed ones.
+func (s *Stream) Len(ctx context.Context) (int64, error) {
+	return s.redis.XLen(ctx, s.name)
+}
+
+// Pending returns up to count messages delivered to the consumers of the group and not acknowledged yet, from the oldest one.
+func (s *Stream) Pending(ctx context.Context, group string, count int64) ([]redis.PendingMessage, error) {
+	return s.redis.XPending(ctx, s.name, group, count)
+}
+
+func (s *Stream) publish(ctx context.Context, body mq.Message, remainingRetries *int) error {
+	values := map[string]interface{}{fieldBody: []byte(body)}
+	if remainingRetries != nil {
+		values[fieldRemainingRetries] = *remainingRetries
+	}
+
+	if _, err := s.redis.XAdd(ctx, s.name, values, s.maxLen); err != nil {
+		return fmt.Errorf("publish to stream %s: %w", s.name, err)
+	}
+
+	return nil
+}
+
+// message is a decoded stream entry
+type message struct {
+	id   string
+	body mq.Message
+	// remainingRetries is nil if the message has never been retried
+	remainingRetries *int
+}
+
+func decodeMessage(m redis.StreamMessage) (message, error) {
+	body, ok := m.Values[fieldBody].(string)
+	if !ok {
+		return message{}, fmt.Errorf("message %s has no body", m.ID)
+	}
+
+	msg := message{id: m.ID, body: mq.Message(body)}
+	if raw, ok := m.Values[fieldRemainingRetries].(string); ok {
+		retries, err := strconv.Atoi(raw)
+		if err != nil {
+			return message{}, fmt.Errorf("message %s has invalid remaining retries %q", m.ID, raw)
+		}
+		msg.remainingRetries = &retries
+	}
+
+	return msg, nil
+}
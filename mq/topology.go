@@ -0,0 +1,321 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// Topology describes exchanges, queues and bindings declared by a service.
+// It can be embedded into the service config loaded by viper, or loaded from a separate file with LoadTopology.
+//
+//	exchanges:
+//	  - name: deposits
+//	    kind: topic
+//	queues:
+//	  - name: deposits.notifier
+//	    durable: true
+//	    dead_letter:
+//	      exchange: deposits.dlx
+//	bindings:
+//	  - exchange: deposits
+//	    queue: deposits.notifier
+//	    key: "deposit.*"
+type Topology struct {
+	Exchanges []ExchangeConfig `mapstructure:"exchanges"`
+	Queues    []QueueConfig    `mapstructure:"queues"`
+	Bindings  []BindingConfig  `mapstructure:"bindings"`
+}
+
+// ExchangeConfig describes a durable exchange.
+type ExchangeConfig struct {
+	Name       ExchangeName           `mapstructure:"name"`
+	Kind       string                 `mapstructure:"kind"`
+	AutoDelete bool                   `mapstructure:"auto_delete"`
+	Internal   bool                   `mapstructure:"internal"`
+	Args       map[string]interface{} `mapstructure:"args"`
+}
+
+type QueueConfig struct {
+	Name       QueueName              `mapstructure:"name"`
+	Durable    bool                   `mapstructure:"durable"`
+	AutoDelete bool                   `mapstructure:"auto_delete"`
+	Exclusive  bool                   `mapstructure:"exclusive"`
+	Args       map[string]interface{} `mapstructure:"args"`
+
+	// DeadLetter is optional, it's merged into the queue arguments.
+	DeadLetter *DeadLetterConfig `mapstructure:"dead_letter"`
+}
+
+type DeadLetterConfig struct {
+	Exchange ExchangeName `mapstructure:"exchange"`
+	// RoutingKey is optional, the original routing key is used by default.
+	RoutingKey ExchangeKey `mapstructure:"routing_key"`
+}
+
+type BindingConfig struct {
+	Exchange ExchangeName `mapstructure:"exchange"`
+	Queue    QueueName    `mapstructure:"queue"`
+	Key      ExchangeKey  `mapstructure:"key"`
+}
+
+// TopologyDiff lists the differences between a topology and the broker state.
+type TopologyDiff struct {
+	MissingExchanges []ExchangeName
+	MissingQueues    []QueueName
+
+	// MismatchedExchanges and MismatchedQueues exist with another kind or other arguments,
+	// the broker rejects applying the topology for them.
+	MismatchedExchanges []ExchangeName
+	MismatchedQueues    []QueueName
+
+	// UnverifiedBindings lists all bindings of the topology, since bindings can't be inspected over AMQP.
+	// Applying a binding which already exists is a no-op.
+	UnverifiedBindings []BindingConfig
+}
+
+// HasMissing returns true if applying the topology would create an exchange or a queue.
+func (d TopologyDiff) HasMissing() bool {
+	return len(d.MissingExchanges) > 0 || len(d.MissingQueues) > 0
+}
+
+// HasMismatched returns true if applying the topology would fail on an existing exchange or queue.
+func (d TopologyDiff) HasMismatched() bool {
+	return len(d.MismatchedExchanges) > 0 || len(d.MismatchedQueues) > 0
+}
+
+// LoadTopology reads the topology from a config file, e.g. YAML.
+func LoadTopology(path string) (Topology, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return Topology{}, fmt.Errorf("read topology config: %w", err)
+	}
+
+	var topology Topology
+	if err := v.Unmarshal(&topology); err != nil {
+		return Topology{}, fmt.Errorf("unmarshal topology config: %w", err)
+	}
+
+	return topology, topology.Validate()
+}
+
+func (t Topology) Validate() error {
+	for _, e := range t.Exchanges {
+		if e.Name == "" {
+			return errors.New("exchange name is empty")
+		}
+		if e.Kind == "" {
+			return fmt.Errorf("exchange %s: kind is empty", e.Name)
+		}
+	}
+
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			return errors.New("queue name is empty")
+		}
+		if q.DeadLetter != nil && q.DeadLetter.Exchange == "" {
+			return fmt.Errorf("queue %s: dead letter exchange is empty", q.Name)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if b.Exchange == "" || b.Queue == "" {
+			return fmt.Errorf("binding %s -> %s: exchange and queue are required", b.Exchange, b.Queue)
+		}
+	}
+
+	return nil
+}
+
+func (q QueueConfig) arguments() amqp.Table {
+	args := make(amqp.Table, len(q.Args)+2)
+	for k, v := range q.Args {
+		args[k] = v
+	}
+
+	if q.DeadLetter != nil {
+		args[headerDeadLetterExchange] = string(q.DeadLetter.Exchange)
+		if q.DeadLetter.RoutingKey != "" {
+			args[headerDeadLetterRoutingKey] = string(q.DeadLetter.RoutingKey)
+		}
+	}
+
+	return args
+}
+
+// ApplyTopology declares all exchanges, queues and bindings of the topology.
+// Declarations are idempotent, the topology is applied again after every reconnect.
+func (c *Client) ApplyTopology(t Topology) error {
+	if err := c.applyTopology(t); err != nil {
+		return err
+	}
+
+	c.rememberTopology(t)
+	return nil
+}
+
+// rememberTopology keeps the topology for reconnects, a topology applied repeatedly is kept once
+func (c *Client) rememberTopology(t Topology) {
+	for _, applied := range c.topologies {
+		if reflect.DeepEqual(applied, t) {
+			return
+		}
+	}
+
+	c.topologies = append(c.topologies, t)
+}
+
+func (c *Client) applyTopology(t Topology) error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("invalid topology: %w", err)
+	}
+
+	// a dedicated channel is used, since a failed declaration closes the channel
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return fmt.Errorf("open channel: %w", err)
+	}
+	defer func() { _ = ch.Close() }()
+
+	for _, e := range t.Exchanges {
+		err := ch.ExchangeDeclare(string(e.Name), e.Kind, true, e.AutoDelete, e.Internal, false, e.Args)
+		if err != nil {
+			return fmt.Errorf("declare exchange %s: %w", e.Name, err)
+		}
+	}
+
+	for _, q := range t.Queues {
+		_, err := ch.QueueDeclare(string(q.Name), q.Durable, q.AutoDelete, q.Exclusive, false, q.arguments())
+		if err != nil {
+			return fmt.Errorf("declare queue %s: %w", q.Name, err)
+		}
+	}
+
+	for _, b := range t.Bindings {
+		err := ch.QueueBind(string(b.Queue), string(b.Key), string(b.Exchange), false, nil)
+		if err != nil {
+			return fmt.Errorf("bind queue %s to exchange %s: %w", b.Queue, b.Exchange, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) reapplyTopologies() error {
+	for _, t := range c.topologies {
+		if err := c.applyTopology(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DiffTopology is the dry-run mode of ApplyTopology, it reports what is missing on the broker without declaring anything.
+func (c *Client) DiffTopology(t Topology) (TopologyDiff, error) {
+	if err := t.Validate(); err != nil {
+		return TopologyDiff{}, fmt.Errorf("invalid topology: %w", err)
+	}
+
+	diff := TopologyDiff{UnverifiedBindings: t.Bindings}
+
+	for _, e := range t.Exchanges {
+		state, err := c.passiveCheck(func(ch *amqp.Channel) error {
+			return ch.ExchangeDeclarePassive(string(e.Name), e.Kind, true, e.AutoDelete, e.Internal, false, e.Args)
+		})
+		if err != nil {
+			return TopologyDiff{}, fmt.Errorf("inspect exchange %s: %w", e.Name, err)
+		}
+
+		switch state {
+		case entityMissing:
+			diff.MissingExchanges = append(diff.MissingExchanges, e.Name)
+		case entityMismatched:
+			diff.MismatchedExchanges = append(diff.MismatchedExchanges, e.Name)
+		}
+	}
+
+	for _, q := range t.Queues {
+		state, err := c.passiveCheck(func(ch *amqp.Channel) error {
+			_, err := ch.QueueInspect(string(q.Name))
+			return err
+		})
+		if err != nil {
+			return TopologyDiff{}, fmt.Errorf("inspect queue %s: %w", q.Name, err)
+		}
+
+		switch state {
+		case entityMissing:
+			diff.MissingQueues = append(diff.MissingQueues, q.Name)
+		case entityMismatched:
+			diff.MismatchedQueues = append(diff.MismatchedQueues, q.Name)
+		}
+	}
+
+	return diff, nil
+}
+
+// entityState is the state of an exchange or a queue on the broker compared to its declaration
+type entityState int
+
+const (
+	entityExists entityState = iota
+	entityMissing
+	entityMismatched
+)
+
+// passiveCheck runs a passive declaration on a new channel, since the broker closes the channel if the entity is missing
+func (c *Client) passiveCheck(check func(ch *amqp.Channel) error) (entityState, error) {
+	ch, err := c.connection().Channel()
+	if err != nil {
+		return entityExists, fmt.Errorf("open channel: %w", err)
+	}
+	defer func() { _ = ch.Close() }()
+
+	return passiveCheckState(check(ch))
+}
+
+// passiveCheckState maps the error of a passive declaration to the entity state
+func passiveCheckState(err error) (entityState, error) {
+	var amqpErr *amqp.Error
+	switch {
+	case err == nil:
+		return entityExists, nil
+	case errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound:
+		return entityMissing, nil
+	case errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed:
+		// the entity exists with another kind or other arguments
+		return entityMismatched, nil
+	default:
+		return entityExists, err
+	}
+}
+
+// ApplyTopology declares all exchanges, queues and bindings of the topology.
+func (b *MemoryBroker) ApplyTopology(t Topology) error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("invalid topology: %w", err)
+	}
+
+	for _, e := range t.Exchanges {
+		if err := b.declareExchange(e.Name, e.Kind); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range t.Queues {
+		b.declareQueue(q.Name, q.arguments())
+	}
+
+	for _, binding := range t.Bindings {
+		if err := b.bind(binding.Exchange, binding.Queue, binding.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package mq
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopologyYAML = `
+exchanges:
+  - name: deposits
+    kind: topic
+  - name: deposits.dlx
+    kind: fanout
+queues:
+  - name: deposits.notifier
+    durable: true
+    args:
+      x-max-priority: 10
+    dead_letter:
+      exchange: deposits.dlx
+  - name: deposits.dlq
+    durable: true
+bindings:
+  - exchange: deposits
+    queue: deposits.notifier
+    key: "deposit.*"
+  - exchange: deposits.dlx
+    queue: deposits.dlq
+`
+
+func TestLoadTopology(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testTopologyYAML), 0o600))
+
+	topology, err := LoadTopology(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, []ExchangeConfig{
+		{Name: "deposits", Kind: ExchangeKindTopic},
+		{Name: "deposits.dlx", Kind: ExchangeKindFanout},
+	}, topology.Exchanges)
+	require.Len(t, topology.Queues, 2)
+	assert.Equal(t, QueueName("deposits.notifier"), topology.Queues[0].Name)
+	assert.True(t, topology.Queues[0].Durable)
+	assert.Equal(t, &DeadLetterConfig{Exchange: "deposits.dlx"}, topology.Queues[0].DeadLetter)
+	assert.EqualValues(t, 10, topology.Queues[0].arguments()["x-max-priority"])
+	assert.Equal(t, "deposits.dlx", topology.Queues[0].arguments()["x-dead-letter-exchange"])
+	assert.Equal(t, []BindingConfig{
+		{Exchange: "deposits", Queue: "deposits.notifier", Key: "deposit.*"},
+		{Exchange: "deposits.dlx", Queue: "deposits.dlq"},
+	}, topology.Bindings)
+}
+
+func TestTopology_Validate(t *testing.T) {
+	assert.NoError(t, Topology{}.Validate())
+	assert.Error(t, Topology{Exchanges: []ExchangeConfig{{Name: "e"}}}.Validate())
+	assert.Error(t, Topology{Queues: []QueueConfig{{Name: "q", DeadLetter: &DeadLetterConfig{}}}}.Validate())
+	assert.Error(t, Topology{Bindings: []BindingConfig{{Exchange: "e"}}}.Validate())
+}
+
+func TestMemoryBroker_ApplyTopology(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testTopologyYAML), 0o600))
+	topology, err := LoadTopology(path)
+	require.NoError(t, err)
+
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.ApplyTopology(topology))
+	// applying twice is a no-op
+	require.NoError(t, broker.ApplyTopology(topology))
+
+	consumer := broker.InitConsumer("deposits.notifier", DefaultConsumerOptions(1), MessageProcessorFunc(func(Message) error {
+		return ErrPoisonMessage
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.StartConsumers(ctx, consumer))
+
+	require.NoError(t, broker.InitExchange("deposits").PublishWithKey([]byte("deposit"), "deposit.btc"))
+	require.NoError(t, broker.WaitForNack(ctx, "deposits.notifier", 1))
+
+	assert.Equal(t, []Message{Message("deposit")}, broker.PublishedMessages("deposits.dlq"))
+}
+
+func TestClient_RememberTopology(t *testing.T) {
+	topology := Topology{Queues: []QueueConfig{{Name: "q", Args: map[string]interface{}{"x-max-priority": 10}}}}
+	other := Topology{Queues: []QueueConfig{{Name: "other"}}}
+
+	c := &Client{}
+	c.rememberTopology(topology)
+	c.rememberTopology(other)
+	// applying the same topology again must not reapply it twice on every reconnect
+	c.rememberTopology(Topology{Queues: []QueueConfig{{Name: "q", Args: map[string]interface{}{"x-max-priority": 10}}}})
+
+	assert.Equal(t, []Topology{topology, other}, c.topologies)
+}
+
+func TestPassiveCheckState(t *testing.T) {
+	state, err := passiveCheckState(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, entityExists, state)
+
+	state, err = passiveCheckState(&amqp.Error{Code: amqp.NotFound})
+	assert.NoError(t, err)
+	assert.Equal(t, entityMissing, state)
+
+	state, err = passiveCheckState(&amqp.Error{Code: amqp.PreconditionFailed})
+	assert.NoError(t, err)
+	assert.Equal(t, entityMismatched, state)
+
+	_, err = passiveCheckState(amqp.ErrClosed)
+	assert.Error(t, err)
+}
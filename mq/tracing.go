@@ -0,0 +1,97 @@
+package mq
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const headerRequestID = "x-request-id"
+
+// tracePropagator reads and writes the W3C traceparent and tracestate headers
+var tracePropagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns the context carrying the request ID, which is propagated to consumers of published messages.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID of the context, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// headerCarrier adapts message headers to the otel propagators
+type headerCarrier map[string]interface{}
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectContext returns a copy of the headers with the trace context and the request ID of the context added.
+// Headers which are already set are kept, so republished messages keep their original context.
+// Publishes with a context inject it automatically, it's needed only to store headers for a later publish.
+func InjectContext(ctx context.Context, headers map[string]interface{}) map[string]interface{} {
+	carrier := headerCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		carrier[headerRequestID] = requestID
+	}
+
+	if len(carrier) == 0 {
+		return headers
+	}
+
+	result := make(map[string]interface{}, len(headers)+len(carrier))
+	for k, v := range carrier {
+		result[k] = v
+	}
+	for k, v := range headers {
+		result[k] = v
+	}
+
+	return result
+}
+
+// ExtractContext returns the context with the remote trace context and the request ID of the message headers.
+// Consumers extract them into the context of processors automatically.
+func ExtractContext(ctx context.Context, headers map[string]interface{}) context.Context {
+	ctx = tracePropagator.Extract(ctx, headerCarrier(headers))
+	if requestID, ok := headers[headerRequestID].(string); ok && requestID != "" {
+		ctx = ContextWithRequestID(ctx, requestID)
+	}
+
+	return ctx
+}
+
+// LogFields returns the request ID and the trace and span IDs of the context to correlate logs.
+func LogFields(ctx context.Context) log.Fields {
+	fields := log.Fields{}
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		fields["request_id"] = requestID
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		fields["trace_id"] = spanContext.TraceID().String()
+		fields["span_id"] = spanContext.SpanID().String()
+	}
+
+	return fields
+}
@@ -0,0 +1,86 @@
+package mq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func tracedContext(t *testing.T) context.Context {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	state, err := trace.ParseTraceState("vendor=value")
+	require.NoError(t, err)
+
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: state,
+	}))
+
+	return ContextWithRequestID(ctx, "req-1")
+}
+
+func TestInjectContext(t *testing.T) {
+	assert.Nil(t, InjectContext(context.Background(), nil))
+
+	headers := map[string]interface{}{"coin": "btc", headerRequestID: "original"}
+	injected := InjectContext(tracedContext(t), headers)
+
+	assert.Equal(t, map[string]interface{}{
+		"coin":          "btc",
+		"traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":    "vendor=value",
+		headerRequestID: "original",
+	}, injected)
+	assert.Len(t, headers, 2, "headers must not be modified")
+
+	ctx := ExtractContext(context.Background(), injected)
+	spanContext := trace.SpanContextFromContext(ctx)
+	assert.True(t, spanContext.IsRemote())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID().String())
+	assert.Equal(t, "vendor=value", spanContext.TraceState().String())
+	assert.Equal(t, "original", RequestIDFromContext(ctx))
+
+	assert.Equal(t, log.Fields{
+		"request_id": "original",
+		"trace_id":   "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id":    "00f067aa0ba902b7",
+	}, LogFields(ctx))
+	assert.Empty(t, LogFields(context.Background()))
+}
+
+func TestConsumer_ContextPropagation(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := broker.InitQueue("deposits")
+	require.NoError(t, queue.Declare())
+
+	received := make(chan context.Context, 1)
+	consumer := broker.InitDeliveryConsumer("deposits", DefaultConsumerOptions(1),
+		DeliveryProcessorFunc(func(ctx context.Context, _ Delivery) error {
+			received <- ctx
+			return nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, consumer.Start(ctx))
+
+	require.NoError(t, PublishWithContext(tracedContext(t), queue, []byte("deposit"), PublishConfig{}))
+
+	select {
+	case processorCtx := <-received:
+		assert.Equal(t, "req-1", RequestIDFromContext(processorCtx))
+		assert.Equal(t, "00f067aa0ba902b7", trace.SpanContextFromContext(processorCtx).SpanID().String())
+	case <-ctx.Done():
+		t.Fatal("message is not processed")
+	}
+}
@@ -0,0 +1,182 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrPoisonMessage marks messages which can never be processed, e.g. because they cannot be decoded.
+// Such messages are rejected without requeue instead of being retried, so they are routed
+// to the dead letter exchange of the queue if it is configured, and dropped otherwise.
+var ErrPoisonMessage = errors.New("poison message")
+
+// Publisher publishes raw message bodies.
+// Queue implements it, ExchangePublisher adapts an Exchange with a routing key.
+type Publisher interface {
+	PublishWithConfig(body []byte, cfg PublishConfig) error
+}
+
+// PublisherFunc is an adapter to allow to use
+// an ordinary functions as mq Publisher.
+type PublisherFunc func(body []byte, cfg PublishConfig) error
+
+func (f PublisherFunc) PublishWithConfig(body []byte, cfg PublishConfig) error {
+	return f(body, cfg)
+}
+
+// ContextPublisher is implemented by the queues of Client and MemoryBroker and by ExchangePublisher.
+// It isn't part of Queue and Publisher to keep their other implementations, e.g. mocks, compatible.
+type ContextPublisher interface {
+	// PublishWithContext propagates the trace context and the request ID of the context to consumers.
+	PublishWithContext(ctx context.Context, body []byte, cfg PublishConfig) error
+}
+
+// PublishWithContext publishes the body with the trace context and the request ID of the context.
+// They are injected into the headers if the publisher doesn't implement ContextPublisher.
+func PublishWithContext(ctx context.Context, p Publisher, body []byte, cfg PublishConfig) error {
+	if cp, ok := p.(ContextPublisher); ok {
+		return cp.PublishWithContext(ctx, body, cfg)
+	}
+
+	cfg.Headers = InjectContext(ctx, cfg.Headers)
+	return p.PublishWithConfig(body, cfg)
+}
+
+// ExchangePublisher returns a Publisher which publishes to the exchange with the given routing key,
+// it also implements ContextPublisher.
+// Publishing fails if the exchange doesn't implement ExchangeConfigPublisher.
+func ExchangePublisher(e Exchange, key ExchangeKey) Publisher {
+	return &exchangePublisher{exchange: e, key: key}
+}
+
+type exchangePublisher struct {
+	exchange Exchange
+	key      ExchangeKey
+}
+
+func (p *exchangePublisher) PublishWithConfig(body []byte, cfg PublishConfig) error {
+	e, ok := p.exchange.(ExchangeConfigPublisher)
+	if !ok {
+		return fmt.Errorf("exchange %T doesn't support publish config", p.exchange)
+	}
+	return e.PublishWithConfig(body, p.key, cfg)
+}
+
+func (p *exchangePublisher) PublishWithContext(ctx context.Context, body []byte, cfg PublishConfig) error {
+	if e, ok := p.exchange.(ExchangeContextPublisher); ok {
+		return e.PublishWithContext(ctx, body, p.key, cfg)
+	}
+
+	cfg.Headers = InjectContext(ctx, cfg.Headers)
+	return p.PublishWithConfig(body, cfg)
+}
+
+type typedConfig struct {
+	codec Codec
+}
+
+type TypedOption func(cfg *typedConfig)
+
+// WithCodec sets the codec of a typed publisher or consumer. JSONCodec is used by default.
+func WithCodec(codec Codec) TypedOption {
+	return func(cfg *typedConfig) {
+		cfg.codec = codec
+	}
+}
+
+func newTypedConfig(opts ...TypedOption) typedConfig {
+	cfg := typedConfig{codec: JSONCodec{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// TypedPublisher encodes values of type T with its codec before publishing them.
+type TypedPublisher[T any] struct {
+	publisher Publisher
+	codec     Codec
+}
+
+func NewTypedPublisher[T any](publisher Publisher, opts ...TypedOption) *TypedPublisher[T] {
+	cfg := newTypedConfig(opts...)
+	return &TypedPublisher[T]{
+		publisher: publisher,
+		codec:     cfg.codec,
+	}
+}
+
+func (p *TypedPublisher[T]) Publish(v T) error {
+	return p.PublishWithConfig(v, PublishConfig{})
+}
+
+// PublishWithConfig publishes v, the content type of cfg is overridden by the codec one.
+func (p *TypedPublisher[T]) PublishWithConfig(v T, cfg PublishConfig) error {
+	body, err := p.encode(v, &cfg)
+	if err != nil {
+		return err
+	}
+	return p.publisher.PublishWithConfig(body, cfg)
+}
+
+// PublishContext publishes v with the trace context and the request ID of the context, see PublishWithContext.
+func (p *TypedPublisher[T]) PublishContext(ctx context.Context, v T) error {
+	return p.PublishWithContext(ctx, v, PublishConfig{})
+}
+
+// PublishWithContext publishes v with the trace context and the request ID of the context,
+// the content type of cfg is overridden by the codec one.
+func (p *TypedPublisher[T]) PublishWithContext(ctx context.Context, v T, cfg PublishConfig) error {
+	body, err := p.encode(v, &cfg)
+	if err != nil {
+		return err
+	}
+	return PublishWithContext(ctx, p.publisher, body, cfg)
+}
+
+func (p *TypedPublisher[T]) encode(v T, cfg *PublishConfig) ([]byte, error) {
+	body, err := p.codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal message: %w", err)
+	}
+
+	cfg.ContentType = p.codec.ContentType()
+	return body, nil
+}
+
+type TypedProcessor[T any] interface {
+	Process(T) error
+}
+
+// TypedProcessorFunc is an adapter to allow to use
+// an ordinary functions as mq TypedProcessor.
+type TypedProcessorFunc[T any] func(v T) error
+
+func (f TypedProcessorFunc[T]) Process(v T) error {
+	return f(v)
+}
+
+// NewTypedConsumer creates a consumer decoding messages into values of type T.
+// Messages which cannot be decoded are treated as poison messages, see ErrPoisonMessage.
+func NewTypedConsumer[T any](
+	client *Client,
+	queueName QueueName,
+	options *ConsumerOptions,
+	processor TypedProcessor[T],
+	opts ...TypedOption,
+) Consumer {
+	return client.InitConsumer(queueName, options, NewTypedMessageProcessor(processor, opts...))
+}
+
+// NewTypedMessageProcessor adapts a TypedProcessor to a MessageProcessor.
+func NewTypedMessageProcessor[T any](processor TypedProcessor[T], opts ...TypedOption) MessageProcessor {
+	cfg := newTypedConfig(opts...)
+	return MessageProcessorFunc(func(message Message) error {
+		var v T
+		if err := cfg.codec.Unmarshal(message, &v); err != nil {
+			return fmt.Errorf("%w: unmarshal message: %v", ErrPoisonMessage, err)
+		}
+		return processor.Process(v)
+	})
+}
@@ -0,0 +1,113 @@
+package mq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testEvent struct {
+	ID     int    `json:"id" codec:"id"`
+	Status string `json:"status" codec:"status"`
+}
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		codec       Codec
+		contentType string
+	}{
+		{"json", JSONCodec{}, ContentTypeJSON},
+		{"msgpack", MsgpackCodec{}, ContentTypeMsgpack},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.contentType, tt.codec.ContentType())
+
+			data, err := tt.codec.Marshal(testEvent{ID: 1, Status: "confirmed"})
+			assert.NoError(t, err)
+
+			var got testEvent
+			assert.NoError(t, tt.codec.Unmarshal(data, &got))
+			assert.Equal(t, testEvent{ID: 1, Status: "confirmed"}, got)
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	codec := ProtobufCodec{}
+
+	data, err := codec.Marshal(wrapperspb.String("hello"))
+	assert.NoError(t, err)
+
+	var got *wrapperspb.StringValue
+	assert.NoError(t, codec.Unmarshal(data, &got))
+	assert.Equal(t, "hello", got.GetValue())
+
+	_, err = codec.Marshal(testEvent{})
+	assert.Error(t, err)
+}
+
+func TestTypedPublisher(t *testing.T) {
+	var (
+		published []byte
+		cfg       PublishConfig
+	)
+	publisher := NewTypedPublisher[testEvent](PublisherFunc(func(body []byte, c PublishConfig) error {
+		published, cfg = body, c
+		return nil
+	}))
+
+	err := publisher.Publish(testEvent{ID: 2, Status: "pending"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":2,"status":"pending"}`, string(published))
+	assert.Equal(t, ContentTypeJSON, cfg.ContentType)
+}
+
+func TestTypedPublisher_PublishContext(t *testing.T) {
+	var cfg PublishConfig
+	publisher := NewTypedPublisher[testEvent](PublisherFunc(func(_ []byte, c PublishConfig) error {
+		cfg = c
+		return nil
+	}))
+
+	assert.NoError(t, publisher.PublishContext(tracedContext(t), testEvent{ID: 4}))
+	assert.Equal(t, ContentTypeJSON, cfg.ContentType)
+	assert.Equal(t, "req-1", cfg.Headers[headerRequestID], "the context must be injected into the headers")
+}
+
+// plainExchange implements only Exchange, like mocks of it
+type plainExchange struct {
+	Exchange
+}
+
+func TestExchangePublisher(t *testing.T) {
+	broker := NewMemoryBroker()
+	exchange := broker.InitExchange("deposits")
+	assert.NoError(t, exchange.Declare(ExchangeKindDirect))
+	assert.NoError(t, ExchangePublisher(exchange, "btc").PublishWithConfig([]byte("1"), PublishConfig{}))
+
+	err := ExchangePublisher(plainExchange{Exchange: exchange}, "btc").PublishWithConfig([]byte("1"), PublishConfig{})
+	assert.Error(t, err)
+
+	_, ok := ExchangePublisher(exchange, "btc").(ContextPublisher)
+	assert.True(t, ok, "the context must be propagated to the exchange")
+}
+
+func TestTypedMessageProcessor(t *testing.T) {
+	var got testEvent
+	processor := NewTypedMessageProcessor[testEvent](TypedProcessorFunc[testEvent](func(v testEvent) error {
+		got = v
+		return nil
+	}))
+
+	err := processor.Process(Message(`{"id":3,"status":"failed"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, testEvent{ID: 3, Status: "failed"}, got)
+
+	err = processor.Process(Message(`not a json`))
+	assert.True(t, errors.Is(err, ErrPoisonMessage))
+}
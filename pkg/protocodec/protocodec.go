@@ -0,0 +1,43 @@
+// Package protocodec encodes values with Protocol Buffers for the codecs of mq and cache/redis.
+package protocodec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Marshal encodes the value, it must implement proto.Message.
+func Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal decodes into the message or into a pointer to the message pointer,
+// e.g. a pointer to T of generic callers, where T is a pointer to the generated message type.
+func Unmarshal(data []byte, v interface{}) error {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, msg)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+
+	target := reflect.New(rv.Elem().Type().Elem())
+	msg, ok := target.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", target.Interface())
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return err
+	}
+
+	rv.Elem().Set(target)
+	return nil
+}